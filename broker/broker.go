@@ -0,0 +1,289 @@
+// Package broker is a small publish/subscribe message broker. Messages
+// published to a topic are copied to every subscription on it; each
+// subscription has a bounded buffer and delivers with at-least-once
+// semantics: a message stays owned by the subscription until it is
+// acknowledged, and is redelivered if it is negatively acknowledged or
+// not acknowledged within the ack timeout.
+//
+// Durable subscriptions keep buffering while no consumer is attached and
+// can be re-attached by name; non-durable ones are removed when their
+// consumer detaches. State is held in memory only.
+//
+// The same broker can be used in-process or served over TCP with a
+// line-delimited JSON protocol (see Server and Client).
+package broker
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/shameerb/projects-golang/errs"
+)
+
+// Options configures a Broker.
+type Options struct {
+	// BufferSize bounds the unacknowledged messages, pending or in
+	// flight, held per subscription. Publish blocks while any
+	// subscription on the topic is full. Zero means 1024.
+	BufferSize int
+	// AckTimeout is how long a delivery may stay unacknowledged before it
+	// is redelivered. Zero means 30s.
+	AckTimeout time.Duration
+}
+
+// Message is a published message.
+type Message struct {
+	ID        uint64    `json:"id"`
+	Topic     string    `json:"topic"`
+	Payload   []byte    `json:"payload"`
+	Published time.Time `json:"published"`
+}
+
+// Subscriber is implemented by in-process and remote subscriptions.
+type Subscriber interface {
+	// Receive blocks until a message is available or ctx is done.
+	Receive(ctx context.Context) (*Delivery, error)
+	// Close detaches the consumer. Unacknowledged deliveries are
+	// redelivered to the next consumer of a durable subscription.
+	Close() error
+}
+
+// Publisher is implemented by Broker and Client.
+type Publisher interface {
+	Publish(ctx context.Context, topic string, payload []byte) (uint64, error)
+}
+
+// Delivery is one delivery of a message to a consumer.
+type Delivery struct {
+	Message
+	// Tag identifies this delivery; a redelivery gets a new tag.
+	Tag uint64
+	// Attempt is 1 on first delivery and increases on each redelivery.
+	Attempt int
+
+	settle func(ack, requeue bool) error
+}
+
+// Ack acknowledges the delivery, removing the message from the
+// subscription. It fails with errs.ErrNotFound if the delivery was
+// already settled or has timed out and been requeued.
+func (d *Delivery) Ack() error { return d.settle(true, false) }
+
+// Nack rejects the delivery. With requeue the message is delivered
+// again, otherwise it is dropped.
+func (d *Delivery) Nack(requeue bool) error { return d.settle(false, requeue) }
+
+// Broker routes messages from publishers to subscriptions.
+type Broker struct {
+	opts Options
+	done chan struct{}
+
+	mu     sync.Mutex
+	topics map[string]*topic
+	closed bool
+}
+
+type topic struct {
+	nextID uint64
+	subs   map[string]*subscription
+}
+
+// New returns a running Broker. Call Close to stop its redelivery timer.
+func New(opts Options) *Broker {
+	if opts.BufferSize <= 0 {
+		opts.BufferSize = 1024
+	}
+	if opts.AckTimeout <= 0 {
+		opts.AckTimeout = 30 * time.Second
+	}
+	b := &Broker{opts: opts, done: make(chan struct{}), topics: make(map[string]*topic)}
+	go b.redeliverLoop()
+	return b
+}
+
+// Publish sends payload to every subscription on topic and returns the
+// message id. Messages published to a topic with no subscriptions are
+// discarded. Publish blocks while a subscription's buffer is full, until
+// ctx is done or the broker is closed.
+//
+// Fan-out is not atomic: if Publish fails part-way, subscriptions earlier
+// in the fan-out already hold the message. The id is returned with the
+// error so callers can recognise it if they retry.
+func (b *Broker) Publish(ctx context.Context, topicName string, payload []byte) (uint64, error) {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return 0, errs.Wrap(errs.ErrClosed, "broker: publish")
+	}
+	t := b.topic(topicName)
+	t.nextID++
+	msg := Message{ID: t.nextID, Topic: topicName, Payload: payload, Published: time.Now()}
+	subs := make([]*subscription, 0, len(t.subs))
+	for _, s := range t.subs {
+		subs = append(subs, s)
+	}
+	b.mu.Unlock()
+
+	for _, s := range subs {
+		if err := s.push(ctx, msg); err != nil {
+			return msg.ID, err
+		}
+	}
+	return msg.ID, nil
+}
+
+// Subscribe attaches a consumer to the subscription name on topic,
+// creating it if needed. Attaching to a subscription that already has a
+// consumer fails with errs.ErrConflict.
+func (b *Broker) Subscribe(topicName, name string, durable bool) (*Subscription, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil, errs.Wrap(errs.ErrClosed, "broker: subscribe")
+	}
+	t := b.topic(topicName)
+	s, ok := t.subs[name]
+	if !ok {
+		s = newSubscription(b, topicName, name, durable)
+		t.subs[name] = s
+	}
+	gen, err := s.attach()
+	if err != nil {
+		return nil, errs.WrapKey(err, "broker: subscribe", topicName+"/"+name)
+	}
+	return &Subscription{s: s, gen: gen}, nil
+}
+
+// Unsubscribe deletes a subscription and its buffered messages.
+func (b *Broker) Unsubscribe(topicName, name string) error {
+	b.mu.Lock()
+	t, ok := b.topics[topicName]
+	var s *subscription
+	if ok {
+		s = t.subs[name]
+		delete(t.subs, name)
+	}
+	b.mu.Unlock()
+	if s == nil {
+		return errs.WrapKey(errs.ErrNotFound, "broker: unsubscribe", topicName+"/"+name)
+	}
+	s.close()
+	return nil
+}
+
+// Stats describes one subscription.
+type Stats struct {
+	Topic    string
+	Name     string
+	Durable  bool
+	Attached bool
+	Pending  int
+	InFlight int
+}
+
+// Stats returns the state of every subscription.
+func (b *Broker) Stats() []Stats {
+	b.mu.Lock()
+	var subs []*subscription
+	for _, t := range b.topics {
+		for _, s := range t.subs {
+			subs = append(subs, s)
+		}
+	}
+	b.mu.Unlock()
+
+	out := make([]Stats, 0, len(subs))
+	for _, s := range subs {
+		out = append(out, s.stats())
+	}
+	return out
+}
+
+// Close stops the broker. Blocked publishers and receivers return
+// errors wrapping errs.ErrClosed.
+func (b *Broker) Close() error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return errs.Wrap(errs.ErrClosed, "broker: close")
+	}
+	b.closed = true
+	var subs []*subscription
+	for _, t := range b.topics {
+		for _, s := range t.subs {
+			subs = append(subs, s)
+		}
+	}
+	b.mu.Unlock()
+
+	close(b.done)
+	for _, s := range subs {
+		s.close()
+	}
+	return nil
+}
+
+func (b *Broker) isClosed() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.closed
+}
+
+// topic returns the named topic, creating it. b.mu must be held.
+func (b *Broker) topic(name string) *topic {
+	t, ok := b.topics[name]
+	if !ok {
+		t = &topic{subs: make(map[string]*subscription)}
+		b.topics[name] = t
+	}
+	return t
+}
+
+// removeIfTransient deletes a non-durable subscription once its consumer
+// has gone.
+func (b *Broker) removeIfTransient(s *subscription) {
+	if s.durable {
+		return
+	}
+	b.mu.Lock()
+	if t, ok := b.topics[s.topic]; ok && t.subs[s.name] == s {
+		delete(t.subs, s.name)
+	}
+	b.mu.Unlock()
+	s.close()
+}
+
+func (b *Broker) redeliverLoop() {
+	interval := b.opts.AckTimeout / 4
+	if interval < 10*time.Millisecond {
+		interval = 10 * time.Millisecond
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-b.done:
+			return
+		case now := <-t.C:
+			b.mu.Lock()
+			var subs []*subscription
+			for _, tp := range b.topics {
+				for _, s := range tp.subs {
+					subs = append(subs, s)
+				}
+			}
+			b.mu.Unlock()
+			for _, s := range subs {
+				s.requeueExpired(now)
+			}
+		}
+	}
+}
+
+var (
+	_ Publisher  = (*Broker)(nil)
+	_ Publisher  = (*Client)(nil)
+	_ Subscriber = (*Subscription)(nil)
+	_ Subscriber = (*RemoteSubscription)(nil)
+)
@@ -0,0 +1,246 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/shameerb/projects-golang/errs"
+)
+
+func receive(t *testing.T, s Subscriber) *Delivery {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	d, err := s.Receive(ctx)
+	if err != nil {
+		t.Fatalf("Receive: %v", err)
+	}
+	return d
+}
+
+// expectEmpty fails if s has a message ready.
+func expectEmpty(t *testing.T, s Subscriber) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if d, err := s.Receive(ctx); err == nil {
+		t.Fatalf("unexpected delivery %q", d.Payload)
+	}
+}
+
+func publish(t *testing.T, p Publisher, topic string, payloads ...string) {
+	t.Helper()
+	for _, s := range payloads {
+		if _, err := p.Publish(context.Background(), topic, []byte(s)); err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+	}
+}
+
+func TestFanOut(t *testing.T) {
+	b := New(Options{})
+	defer b.Close()
+	s1, _ := b.Subscribe("t", "one", false)
+	s2, _ := b.Subscribe("t", "two", false)
+	other, _ := b.Subscribe("u", "one", false)
+	publish(t, b, "t", "hello")
+
+	for _, s := range []*Subscription{s1, s2} {
+		d := receive(t, s)
+		if string(d.Payload) != "hello" || d.Topic != "t" || d.ID != 1 || d.Attempt != 1 {
+			t.Errorf("delivery = %+v", d)
+		}
+		d.Ack()
+	}
+	expectEmpty(t, other)
+}
+
+func TestAckAndNack(t *testing.T) {
+	b := New(Options{})
+	defer b.Close()
+	s, _ := b.Subscribe("t", "s", false)
+	publish(t, b, "t", "a", "b", "c")
+
+	d := receive(t, s)
+	if err := d.Nack(true); err != nil {
+		t.Fatal(err)
+	}
+	d = receive(t, s)
+	if string(d.Payload) != "a" || d.Attempt != 2 {
+		t.Fatalf("requeued delivery = %q attempt %d, want a attempt 2", d.Payload, d.Attempt)
+	}
+	if err := d.Ack(); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Ack(); !errors.Is(err, errs.ErrNotFound) {
+		t.Errorf("second Ack = %v, want ErrNotFound", err)
+	}
+
+	d = receive(t, s)
+	d.Nack(false)
+	if d := receive(t, s); string(d.Payload) != "c" {
+		t.Errorf("after dropping b got %q, want c", d.Payload)
+	}
+}
+
+func TestRedeliveryAfterAckTimeout(t *testing.T) {
+	b := New(Options{AckTimeout: 30 * time.Millisecond})
+	defer b.Close()
+	s, _ := b.Subscribe("t", "s", false)
+	publish(t, b, "t", "a")
+
+	first := receive(t, s)
+	second := receive(t, s)
+	if string(second.Payload) != "a" || second.Attempt != 2 || second.Tag == first.Tag {
+		t.Fatalf("redelivery = %+v", second)
+	}
+	if err := first.Ack(); !errors.Is(err, errs.ErrNotFound) {
+		t.Errorf("Ack of timed-out delivery = %v, want ErrNotFound", err)
+	}
+	if err := second.Ack(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDurableReattach(t *testing.T) {
+	b := New(Options{})
+	defer b.Close()
+	s, _ := b.Subscribe("t", "d", true)
+	if _, err := b.Subscribe("t", "d", true); !errors.Is(err, errs.ErrConflict) {
+		t.Fatalf("second attach = %v, want ErrConflict", err)
+	}
+	publish(t, b, "t", "1", "2")
+	receive(t, s) // left unacknowledged
+	s.Close()
+
+	publish(t, b, "t", "3")
+	s, _ = b.Subscribe("t", "d", true)
+	for i, want := range []string{"1", "2", "3"} {
+		d := receive(t, s)
+		if string(d.Payload) != want {
+			t.Fatalf("delivery %d = %q, want %q", i, d.Payload, want)
+		}
+		d.Ack()
+	}
+}
+
+func TestTransientRemovedOnClose(t *testing.T) {
+	b := New(Options{})
+	defer b.Close()
+	s, _ := b.Subscribe("t", "s", false)
+	s.Close()
+	if st := b.Stats(); len(st) != 0 {
+		t.Errorf("Stats = %+v, want transient subscription gone", st)
+	}
+	if err := s.Close(); !errors.Is(err, errs.ErrClosed) {
+		t.Errorf("second Close = %v", err)
+	}
+}
+
+func TestClosedHandleStaysClosed(t *testing.T) {
+	b := New(Options{})
+	defer b.Close()
+	a, _ := b.Subscribe("t", "d", true)
+	a.Close()
+	c, _ := b.Subscribe("t", "d", true)
+	publish(t, b, "t", "x")
+
+	if _, err := a.Receive(context.Background()); !errors.Is(err, errs.ErrClosed) {
+		t.Fatalf("Receive on closed handle = %v, want ErrClosed", err)
+	}
+	a.Close() // must not detach c
+	if d := receive(t, c); string(d.Payload) != "x" {
+		t.Errorf("current consumer got %q", d.Payload)
+	}
+}
+
+func TestPublishBlocksOnFullBuffer(t *testing.T) {
+	b := New(Options{BufferSize: 2})
+	defer b.Close()
+	s, _ := b.Subscribe("t", "s", false)
+	publish(t, b, "t", "1", "2")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := b.Publish(ctx, "t", []byte("3")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Publish into full buffer = %v, want deadline exceeded", err)
+	}
+
+	done := make(chan error)
+	go func() {
+		_, err := b.Publish(context.Background(), "t", []byte("3"))
+		done <- err
+	}()
+	receive(t, s).Ack()
+	if err := <-done; err != nil {
+		t.Fatalf("Publish after ack = %v", err)
+	}
+}
+
+func TestCloseWakesBlockedPublisher(t *testing.T) {
+	b := New(Options{BufferSize: 1})
+	b.Subscribe("t", "s", true)
+	publish(t, b, "t", "1")
+
+	done := make(chan error)
+	go func() {
+		_, err := b.Publish(context.Background(), "t", []byte("2"))
+		done <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	b.Close()
+	if err := <-done; !errors.Is(err, errs.ErrClosed) {
+		t.Fatalf("blocked Publish after Close = %v, want ErrClosed", err)
+	}
+}
+
+func TestPartialFanOutReturnsID(t *testing.T) {
+	b := New(Options{BufferSize: 1})
+	defer b.Close()
+	b.Subscribe("t", "a", true)
+	b.Subscribe("t", "b", true)
+	publish(t, b, "t", "1")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	id, err := b.Publish(ctx, "t", []byte("2"))
+	if err == nil || id != 2 {
+		t.Errorf("Publish = %d, %v; want id 2 with an error", id, err)
+	}
+}
+
+func TestUnsubscribe(t *testing.T) {
+	b := New(Options{})
+	defer b.Close()
+	s, _ := b.Subscribe("t", "d", true)
+	if err := b.Unsubscribe("t", "d"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Receive(context.Background()); !errors.Is(err, errs.ErrClosed) {
+		t.Errorf("Receive after Unsubscribe = %v", err)
+	}
+	if err := b.Unsubscribe("t", "d"); !errors.Is(err, errs.ErrNotFound) {
+		t.Errorf("second Unsubscribe = %v, want ErrNotFound", err)
+	}
+}
+
+func TestOrderPreserved(t *testing.T) {
+	b := New(Options{BufferSize: 8})
+	defer b.Close()
+	s, _ := b.Subscribe("t", "s", false)
+	go func() {
+		for i := 0; i < 100; i++ {
+			b.Publish(context.Background(), "t", []byte(fmt.Sprint(i)))
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		d := receive(t, s)
+		if string(d.Payload) != fmt.Sprint(i) {
+			t.Fatalf("delivery %d = %q", i, d.Payload)
+		}
+		d.Ack()
+	}
+}
@@ -0,0 +1,240 @@
+package broker
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"sync"
+
+	"github.com/shameerb/projects-golang/errs"
+)
+
+// Client talks to a Server over TCP. It is safe for concurrent use.
+type Client struct {
+	conn net.Conn
+	done chan struct{}
+
+	writeMu sync.Mutex
+	enc     *json.Encoder
+
+	mu      sync.Mutex
+	nextID  uint64
+	pending map[uint64]chan frame
+	subs    map[uint64]*RemoteSubscription
+	err     error // set once the connection is lost
+}
+
+// Dial connects to the server at addr.
+func Dial(ctx context.Context, addr string) (*Client, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(conn), nil
+}
+
+// NewClient returns a Client using conn.
+func NewClient(conn net.Conn) *Client {
+	c := &Client{
+		conn:    conn,
+		done:    make(chan struct{}),
+		enc:     json.NewEncoder(conn),
+		pending: make(map[uint64]chan frame),
+		subs:    make(map[uint64]*RemoteSubscription),
+	}
+	go c.readLoop()
+	return c
+}
+
+// Publish sends payload to topic and returns the message id. As with
+// Broker.Publish, the id is also returned when fan-out fails part-way.
+// It fails with ErrFull if MaxPendingPublishes from this client are
+// already waiting for buffer space on the server.
+func (c *Client) Publish(ctx context.Context, topic string, payload []byte) (uint64, error) {
+	reply, err := c.call(ctx, frame{Op: opPublish, Topic: topic, Payload: payload})
+	return reply.MsgID, err
+}
+
+// Subscribe attaches to the subscription name on topic. The server keeps
+// at most prefetch deliveries unsettled; zero means DefaultPrefetch.
+func (c *Client) Subscribe(ctx context.Context, topic, name string, durable bool, prefetch int) (*RemoteSubscription, error) {
+	if prefetch <= 0 {
+		prefetch = DefaultPrefetch
+	}
+	// The handle is registered before the request goes out so deliveries
+	// that follow the reply always find it.
+	c.mu.Lock()
+	c.nextID++
+	h := c.nextID
+	s := &RemoteSubscription{c: c, h: h, ch: make(chan *Delivery, prefetch), closed: make(chan struct{})}
+	c.subs[h] = s
+	c.mu.Unlock()
+
+	_, err := c.call(ctx, frame{Op: opSubscribe, Topic: topic, Name: name, Durable: durable, Prefetch: prefetch, Sub: h})
+	if err != nil {
+		c.mu.Lock()
+		delete(c.subs, h)
+		c.mu.Unlock()
+		// Unless the server refused, it may have attached before we gave
+		// up waiting; detach so the subscription is not held, with its
+		// prefetched deliveries, until the connection closes.
+		var rerr *remoteError
+		if !errors.As(err, &rerr) {
+			go c.call(context.Background(), frame{Op: opDetach, Sub: h})
+		}
+		return nil, err
+	}
+	return s, nil
+}
+
+// Unsubscribe deletes a subscription on the server.
+func (c *Client) Unsubscribe(ctx context.Context, topic, name string) error {
+	_, err := c.call(ctx, frame{Op: opUnsubscribe, Topic: topic, Name: name})
+	return err
+}
+
+// Close closes the connection. The server requeues unsettled deliveries.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+func (c *Client) call(ctx context.Context, f frame) (frame, error) {
+	ch := make(chan frame, 1)
+	c.mu.Lock()
+	if c.err != nil {
+		err := c.err
+		c.mu.Unlock()
+		return frame{}, err
+	}
+	c.nextID++
+	f.ID = c.nextID
+	c.pending[f.ID] = ch
+	c.mu.Unlock()
+
+	c.writeMu.Lock()
+	err := c.enc.Encode(f)
+	c.writeMu.Unlock()
+	if err != nil {
+		c.forget(f.ID)
+		return frame{}, err
+	}
+
+	select {
+	case reply := <-ch:
+		if reply.Error != "" {
+			return reply, replyError(reply)
+		}
+		return reply, nil
+	case <-ctx.Done():
+		c.forget(f.ID)
+		return frame{}, ctx.Err()
+	case <-c.done:
+		c.mu.Lock()
+		err := c.err
+		c.mu.Unlock()
+		return frame{}, err
+	}
+}
+
+func (c *Client) forget(id uint64) {
+	c.mu.Lock()
+	delete(c.pending, id)
+	c.mu.Unlock()
+}
+
+func (c *Client) readLoop() {
+	dec := json.NewDecoder(bufio.NewReader(c.conn))
+	for {
+		var f frame
+		if err := dec.Decode(&f); err != nil {
+			c.mu.Lock()
+			c.err = errs.Wrapf(errs.ErrClosed, "broker: connection lost (%v)", err)
+			c.mu.Unlock()
+			close(c.done)
+			c.conn.Close()
+			return
+		}
+		switch f.Op {
+		case opReply:
+			c.mu.Lock()
+			ch := c.pending[f.ID]
+			delete(c.pending, f.ID)
+			c.mu.Unlock()
+			if ch != nil {
+				ch <- f
+			}
+		case opDeliver:
+			c.mu.Lock()
+			s := c.subs[f.Sub]
+			c.mu.Unlock()
+			if s != nil && f.Message != nil {
+				s.deliver(f)
+			}
+		}
+	}
+}
+
+// RemoteSubscription is a subscription attached through a Client.
+type RemoteSubscription struct {
+	c  *Client
+	h  uint64
+	ch chan *Delivery
+
+	once   sync.Once
+	closed chan struct{}
+}
+
+// Receive blocks until a message arrives, ctx is done or the subscription
+// or its client is closed.
+func (s *RemoteSubscription) Receive(ctx context.Context) (*Delivery, error) {
+	select {
+	case d := <-s.ch:
+		return d, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-s.closed:
+		return nil, errs.Wrap(errs.ErrClosed, "broker: receive")
+	case <-s.c.done:
+		return nil, errs.Wrap(errs.ErrClosed, "broker: receive")
+	}
+}
+
+// Close detaches from the subscription; the server requeues deliveries
+// not yet settled.
+func (s *RemoteSubscription) Close() error {
+	err := errs.Wrap(errs.ErrClosed, "broker: subscription close")
+	s.once.Do(func() {
+		close(s.closed)
+		_, err = s.c.call(context.Background(), frame{Op: opDetach, Sub: s.h})
+		s.c.mu.Lock()
+		delete(s.c.subs, s.h)
+		s.c.mu.Unlock()
+	})
+	return err
+}
+
+// deliver hands a delivery frame to Receive. The server never sends more
+// than the prefetch count unsettled, so the buffered channel has room.
+func (s *RemoteSubscription) deliver(f frame) {
+	tag := f.Tag
+	d := &Delivery{
+		Message: *f.Message,
+		Tag:     tag,
+		Attempt: f.Attempt,
+		settle: func(ack, requeue bool) error {
+			op := opNack
+			if ack {
+				op = opAck
+			}
+			_, err := s.c.call(context.Background(), frame{Op: op, Sub: s.h, Tag: tag, Requeue: requeue})
+			return err
+		},
+	}
+	select {
+	case s.ch <- d:
+	case <-s.closed:
+	}
+}
@@ -0,0 +1,90 @@
+package broker
+
+import (
+	"errors"
+
+	"github.com/shameerb/projects-golang/errs"
+)
+
+// The TCP protocol exchanges one JSON frame per line. Clients send
+// requests carrying an id, and the server answers each with a "reply"
+// frame echoing the id, with Error and Code set on failure. The server
+// also sends unsolicited "deliver" frames for the client's subscriptions,
+// each identified by the handle the client chose when subscribing.
+// Payloads are base64 encoded, as encoding/json does for []byte.
+const (
+	opPublish     = "publish"     // Topic, Payload -> MsgID
+	opSubscribe   = "subscribe"   // Topic, Name, Durable, Prefetch, Sub
+	opDetach      = "detach"      // Sub
+	opUnsubscribe = "unsubscribe" // Topic, Name
+	opAck         = "ack"         // Sub, Tag
+	opNack        = "nack"        // Sub, Tag, Requeue
+	opReply       = "reply"
+	opDeliver     = "deliver" // Sub, Tag, Attempt, Message
+)
+
+type frame struct {
+	Op       string   `json:"op"`
+	ID       uint64   `json:"id,omitempty"`
+	Topic    string   `json:"topic,omitempty"`
+	Name     string   `json:"name,omitempty"`
+	Durable  bool     `json:"durable,omitempty"`
+	Prefetch int      `json:"prefetch,omitempty"`
+	Payload  []byte   `json:"payload,omitempty"`
+	Sub      uint64   `json:"sub,omitempty"`
+	Tag      uint64   `json:"tag,omitempty"`
+	Requeue  bool     `json:"requeue,omitempty"`
+	Attempt  int      `json:"attempt,omitempty"`
+	MsgID    uint64   `json:"msg_id,omitempty"`
+	Message  *Message `json:"message,omitempty"`
+	Error    string   `json:"error,omitempty"`
+	Code     string   `json:"code,omitempty"`
+}
+
+// DefaultPrefetch is the number of unacknowledged deliveries the server
+// sends a remote subscription when Subscribe is given zero.
+const DefaultPrefetch = 16
+
+// MaxPendingPublishes is the number of publishes a server connection
+// queues while earlier ones wait for buffer space. Publishes beyond it
+// fail with ErrFull.
+const MaxPendingPublishes = 256
+
+var codes = []struct {
+	code string
+	err  error
+}{
+	{"not_found", errs.ErrNotFound},
+	{"closed", errs.ErrClosed},
+	{"conflict", errs.ErrConflict},
+	{"full", errs.ErrFull},
+}
+
+func errorCode(err error) string {
+	for _, c := range codes {
+		if errors.Is(err, c.err) {
+			return c.code
+		}
+	}
+	return ""
+}
+
+// remoteError is an error reported by the server. It unwraps to the
+// matching shared sentinel so errors.Is works on the client side too.
+type remoteError struct {
+	msg string
+	err error
+}
+
+func (e *remoteError) Error() string { return e.msg }
+func (e *remoteError) Unwrap() error { return e.err }
+
+func replyError(f frame) error {
+	e := &remoteError{msg: f.Error}
+	for _, c := range codes {
+		if c.code == f.Code {
+			e.err = c.err
+		}
+	}
+	return e
+}
@@ -0,0 +1,355 @@
+package broker
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"sync"
+
+	"github.com/shameerb/projects-golang/errs"
+)
+
+// Server exposes a Broker over TCP using the line-delimited JSON protocol.
+type Server struct {
+	b *Broker
+
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[*serverConn]struct{}
+	closed    bool
+	wg        sync.WaitGroup
+}
+
+// NewServer returns a Server for b.
+func NewServer(b *Broker) *Server {
+	return &Server{
+		b:         b,
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[*serverConn]struct{}),
+	}
+}
+
+// ListenAndServe listens on the TCP address addr and calls Serve.
+func (s *Server) ListenAndServe(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ln)
+}
+
+// Serve accepts connections on ln until it fails or the server is closed.
+func (s *Server) Serve(ln net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		ln.Close()
+		return errs.Wrap(errs.ErrClosed, "broker: serve")
+	}
+	s.listeners[ln] = struct{}{}
+	s.mu.Unlock()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			delete(s.listeners, ln)
+			s.mu.Unlock()
+			if closed {
+				return errs.Wrap(errs.ErrClosed, "broker: serve")
+			}
+			return err
+		}
+		c := newServerConn(s.b, conn)
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			continue
+		}
+		s.conns[c] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+
+		go func() {
+			defer s.wg.Done()
+			c.serve()
+			s.mu.Lock()
+			delete(s.conns, c)
+			s.mu.Unlock()
+		}()
+	}
+}
+
+// Close stops accepting connections and closes the open ones, detaching
+// their subscriptions. It does not close the Broker.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	for ln := range s.listeners {
+		ln.Close()
+	}
+	for c := range s.conns {
+		c.conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return nil
+}
+
+type serverConn struct {
+	b      *Broker
+	conn   net.Conn
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	writeMu sync.Mutex
+	enc     *json.Encoder
+
+	mu   sync.Mutex
+	subs map[uint64]*remoteSub
+	wg   sync.WaitGroup
+
+	// Publishes wait for buffer space, so they run on their own goroutine
+	// in arrival order; the read loop stays free to process the acks
+	// that make room. At most MaxPendingPublishes wait; more are refused
+	// with ErrFull.
+	pubMu    sync.Mutex
+	pubQueue []frame
+	pubReady chan struct{}
+}
+
+// remoteSub is a subscription held by a connection. credits bounds the
+// deliveries sent but not yet settled by the client.
+type remoteSub struct {
+	sub        *Subscription
+	ctx        context.Context
+	cancel     context.CancelFunc
+	credits    chan struct{}
+	deliveries map[uint64]*Delivery // guarded by serverConn.mu
+}
+
+func newServerConn(b *Broker, conn net.Conn) *serverConn {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &serverConn{
+		b:        b,
+		conn:     conn,
+		ctx:      ctx,
+		cancel:   cancel,
+		enc:      json.NewEncoder(conn),
+		subs:     make(map[uint64]*remoteSub),
+		pubReady: make(chan struct{}, 1),
+	}
+}
+
+func (c *serverConn) serve() {
+	defer c.shutdown()
+	c.wg.Add(1)
+	go c.publishLoop()
+
+	dec := json.NewDecoder(bufio.NewReader(c.conn))
+	for {
+		var f frame
+		if err := dec.Decode(&f); err != nil {
+			return
+		}
+		if f.Op == opPublish && c.enqueuePublish(f) {
+			continue
+		}
+		reply := c.handle(f)
+		reply.Op, reply.ID = opReply, f.ID
+		if err := c.write(reply); err != nil {
+			return
+		}
+		// Deliveries start only after the subscribe reply is on the wire.
+		if f.Op == opSubscribe && reply.Error == "" {
+			c.startPump(f.Sub)
+		}
+	}
+}
+
+func (c *serverConn) handle(f frame) frame {
+	var reply frame
+	var err error
+	switch f.Op {
+	case opPublish:
+		// Only reached when the publish queue is full.
+		err = errs.Wrap(errs.ErrFull, "broker: publish queue")
+	case opSubscribe:
+		err = c.subscribe(f)
+	case opDetach:
+		err = c.detach(f.Sub)
+	case opUnsubscribe:
+		err = c.b.Unsubscribe(f.Topic, f.Name)
+	case opAck, opNack:
+		err = c.settle(f)
+	default:
+		err = errors.New("broker: unknown op " + f.Op)
+	}
+	if err != nil {
+		reply.Error, reply.Code = err.Error(), errorCode(err)
+	}
+	return reply
+}
+
+// enqueuePublish queues f for publishLoop. It reports false if
+// MaxPendingPublishes are already waiting.
+func (c *serverConn) enqueuePublish(f frame) bool {
+	c.pubMu.Lock()
+	if len(c.pubQueue) >= MaxPendingPublishes {
+		c.pubMu.Unlock()
+		return false
+	}
+	c.pubQueue = append(c.pubQueue, f)
+	c.pubMu.Unlock()
+	select {
+	case c.pubReady <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+func (c *serverConn) publishLoop() {
+	defer c.wg.Done()
+	for {
+		c.pubMu.Lock()
+		if len(c.pubQueue) == 0 {
+			c.pubMu.Unlock()
+			select {
+			case <-c.pubReady:
+				continue
+			case <-c.ctx.Done():
+				return
+			}
+		}
+		f := c.pubQueue[0]
+		c.pubQueue[0] = frame{}
+		c.pubQueue = c.pubQueue[1:]
+		c.pubMu.Unlock()
+
+		reply := frame{Op: opReply, ID: f.ID}
+		id, err := c.b.Publish(c.ctx, f.Topic, f.Payload)
+		reply.MsgID = id
+		if err != nil {
+			reply.Error, reply.Code = err.Error(), errorCode(err)
+		}
+		if c.write(reply) != nil {
+			return
+		}
+	}
+}
+
+func (c *serverConn) subscribe(f frame) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, dup := c.subs[f.Sub]; dup {
+		return errs.Wrap(errs.ErrConflict, "broker: subscription handle in use")
+	}
+	sub, err := c.b.Subscribe(f.Topic, f.Name, f.Durable)
+	if err != nil {
+		return err
+	}
+	prefetch := f.Prefetch
+	if prefetch <= 0 {
+		prefetch = DefaultPrefetch
+	}
+	ctx, cancel := context.WithCancel(c.ctx)
+	c.subs[f.Sub] = &remoteSub{
+		sub:        sub,
+		ctx:        ctx,
+		cancel:     cancel,
+		credits:    make(chan struct{}, prefetch),
+		deliveries: make(map[uint64]*Delivery),
+	}
+	return nil
+}
+
+// startPump forwards deliveries for the subscription with handle h while
+// the client has credit.
+func (c *serverConn) startPump(h uint64) {
+	c.mu.Lock()
+	rs := c.subs[h]
+	c.mu.Unlock()
+	if rs == nil {
+		return
+	}
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		for {
+			select {
+			case rs.credits <- struct{}{}:
+			case <-rs.ctx.Done():
+				return
+			}
+			d, err := rs.sub.Receive(rs.ctx)
+			if err != nil {
+				return
+			}
+			c.mu.Lock()
+			rs.deliveries[d.Tag] = d
+			c.mu.Unlock()
+			msg := d.Message
+			err = c.write(frame{Op: opDeliver, Sub: h, Tag: d.Tag, Attempt: d.Attempt, Message: &msg})
+			if err != nil {
+				return
+			}
+		}
+	}()
+}
+
+func (c *serverConn) settle(f frame) error {
+	c.mu.Lock()
+	rs := c.subs[f.Sub]
+	var d *Delivery
+	if rs != nil {
+		d = rs.deliveries[f.Tag]
+		delete(rs.deliveries, f.Tag)
+	}
+	c.mu.Unlock()
+	if d == nil {
+		return errs.Wrap(errs.ErrNotFound, "broker: settle delivery")
+	}
+	<-rs.credits
+
+	if f.Op == opAck {
+		return d.Ack()
+	}
+	return d.Nack(f.Requeue)
+}
+
+func (c *serverConn) detach(h uint64) error {
+	c.mu.Lock()
+	rs := c.subs[h]
+	delete(c.subs, h)
+	c.mu.Unlock()
+	if rs == nil {
+		return errs.Wrap(errs.ErrNotFound, "broker: detach")
+	}
+	rs.cancel()
+	return rs.sub.Close()
+}
+
+func (c *serverConn) write(f frame) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.enc.Encode(f)
+}
+
+// shutdown detaches every subscription, requeueing their unsettled
+// deliveries, and closes the connection.
+func (c *serverConn) shutdown() {
+	c.cancel()
+	c.conn.Close()
+	c.mu.Lock()
+	subs := c.subs
+	c.subs = nil
+	c.mu.Unlock()
+	for _, rs := range subs {
+		rs.sub.Close()
+	}
+	c.wg.Wait()
+}
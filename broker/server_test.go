@@ -0,0 +1,244 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/shameerb/projects-golang/errs"
+)
+
+func startServer(t *testing.T, opts Options) (*Broker, string) {
+	t.Helper()
+	b := New(opts)
+	srv := NewServer(b)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(ln)
+	t.Cleanup(func() {
+		srv.Close()
+		b.Close()
+	})
+	return b, ln.Addr().String()
+}
+
+func dial(t *testing.T, addr string) *Client {
+	t.Helper()
+	c, err := Dial(context.Background(), addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestTCPRoundTrip(t *testing.T) {
+	_, addr := startServer(t, Options{})
+	ctx := context.Background()
+	c := dial(t, addr)
+	s, err := c.Subscribe(ctx, "t", "s", false, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := c.Publish(ctx, "t", []byte("hello"))
+	if err != nil || id != 1 {
+		t.Fatalf("Publish = %d, %v", id, err)
+	}
+	d := receive(t, s)
+	if string(d.Payload) != "hello" || d.ID != 1 || d.Attempt != 1 {
+		t.Fatalf("delivery = %+v", d)
+	}
+	if err := d.Ack(); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Ack(); !errors.Is(err, errs.ErrNotFound) {
+		t.Errorf("second Ack = %v, want ErrNotFound", err)
+	}
+}
+
+func TestTCPNackRequeues(t *testing.T) {
+	_, addr := startServer(t, Options{})
+	c := dial(t, addr)
+	s, _ := c.Subscribe(context.Background(), "t", "s", false, 0)
+	publish(t, c, "t", "a")
+	receive(t, s).Nack(true)
+	if d := receive(t, s); string(d.Payload) != "a" || d.Attempt != 2 {
+		t.Errorf("requeued delivery = %q attempt %d", d.Payload, d.Attempt)
+	}
+}
+
+func TestTCPErrorsMapToSentinels(t *testing.T) {
+	_, addr := startServer(t, Options{})
+	ctx := context.Background()
+	c := dial(t, addr)
+	if _, err := c.Subscribe(ctx, "t", "d", true, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Subscribe(ctx, "t", "d", true, 0); !errors.Is(err, errs.ErrConflict) {
+		t.Errorf("second attach = %v, want ErrConflict", err)
+	}
+	if err := c.Unsubscribe(ctx, "t", "missing"); !errors.Is(err, errs.ErrNotFound) {
+		t.Errorf("Unsubscribe missing = %v, want ErrNotFound", err)
+	}
+}
+
+func TestTCPPrefetchAndReconnect(t *testing.T) {
+	_, addr := startServer(t, Options{})
+	ctx := context.Background()
+	pub := dial(t, addr)
+
+	c1, _ := Dial(ctx, addr)
+	s1, _ := c1.Subscribe(ctx, "t", "d", true, 2)
+	publish(t, pub, "t", "0", "1", "2", "3")
+	receive(t, s1).Ack()
+	receive(t, s1) // left unacknowledged
+	c1.Close()
+
+	c2 := dial(t, addr)
+	var s2 Subscriber
+	// The server detaches the first connection asynchronously.
+	for deadline := time.Now().Add(2 * time.Second); ; {
+		var err error
+		if s2, err = c2.Subscribe(ctx, "t", "d", true, 0); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal(err)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	for _, want := range []string{"1", "2", "3"} {
+		d := receive(t, s2)
+		if string(d.Payload) != want {
+			t.Fatalf("got %q, want %q", d.Payload, want)
+		}
+		if want == "1" && d.Attempt != 2 {
+			t.Errorf("unacked message attempt = %d, want 2", d.Attempt)
+		}
+		d.Ack()
+	}
+}
+
+// A publish waiting for buffer space must not hold up acks sent on the
+// same connection.
+func TestTCPPublishBlockedDoesNotBlockAcks(t *testing.T) {
+	_, addr := startServer(t, Options{BufferSize: 1})
+	c := dial(t, addr)
+	s, _ := c.Subscribe(context.Background(), "t", "s", false, 1)
+	publish(t, c, "t", "1")
+
+	done := make(chan error)
+	go func() {
+		_, err := c.Publish(context.Background(), "t", []byte("2"))
+		done <- err
+	}()
+	d := receive(t, s)
+	time.Sleep(20 * time.Millisecond) // let the publish block server side
+
+	ackErr := make(chan error)
+	go func() { ackErr <- d.Ack() }()
+	select {
+	case err := <-ackErr:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("ack stuck behind blocked publish")
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("Publish = %v", err)
+	}
+	if d := receive(t, s); string(d.Payload) != "2" {
+		t.Errorf("got %q, want 2", d.Payload)
+	}
+}
+
+// Giving up on Subscribe after the server attached must not leave the
+// subscription held by the connection.
+func TestTCPCancelledSubscribeDetaches(t *testing.T) {
+	b, addr := startServer(t, Options{})
+	c := dial(t, addr)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := c.Subscribe(ctx, "t", "d", true, 0); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Subscribe = %v, want context.Canceled", err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		st := b.Stats()
+		if len(st) == 1 && !st[0].Attached {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("subscription still attached: %+v", st)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if _, err := c.Subscribe(context.Background(), "t", "d", true, 0); err != nil {
+		t.Fatalf("re-subscribe = %v", err)
+	}
+}
+
+func TestTCPClientReceiveAfterClose(t *testing.T) {
+	_, addr := startServer(t, Options{})
+	c := dial(t, addr)
+	s, _ := c.Subscribe(context.Background(), "t", "s", false, 0)
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Receive(context.Background()); !errors.Is(err, errs.ErrClosed) {
+		t.Errorf("Receive after Close = %v", err)
+	}
+}
+
+// Publishes pipelined past a blocked one are queued only up to
+// MaxPendingPublishes; the rest are refused.
+func TestTCPPublishQueueBounded(t *testing.T) {
+	b, addr := startServer(t, Options{BufferSize: 1})
+	if _, err := b.Subscribe("t", "d", true); err != nil {
+		t.Fatal(err)
+	}
+	c := dial(t, addr)
+	publish(t, c, "t", "fills the buffer")
+
+	const extra = 5
+	results := make(chan error)
+	for i := 0; i < MaxPendingPublishes+1+extra; i++ {
+		go func() {
+			_, err := c.Publish(context.Background(), "t", []byte("x"))
+			results <- err
+		}()
+	}
+	// One publish may still be queued rather than blocked in the broker,
+	// so either extra or extra+1 are refused.
+	full := 0
+	timeout := time.After(5 * time.Second)
+	for settle := (<-chan time.Time)(nil); ; {
+		select {
+		case err := <-results:
+			if !errors.Is(err, errs.ErrFull) {
+				t.Fatalf("Publish = %v, want ErrFull", err)
+			}
+			if full++; full == extra {
+				settle = time.After(50 * time.Millisecond)
+			}
+			continue
+		case <-settle:
+		case <-timeout:
+		}
+		break
+	}
+	if full < extra || full > extra+1 {
+		t.Errorf("%d publishes refused, want %d or %d", full, extra, extra+1)
+	}
+	// Closing the client unblocks the rest; drain them.
+	go func() {
+		for range results {
+		}
+	}()
+}
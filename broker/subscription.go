@@ -0,0 +1,265 @@
+package broker
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/shameerb/projects-golang/errs"
+)
+
+// Subscription is an in-process consumer attached to a subscription.
+// Once closed it stays closed, even if another consumer later attaches
+// to the same durable subscription.
+type Subscription struct {
+	s    *subscription
+	gen  uint64 // the attachment this handle owns
+	once sync.Once
+}
+
+// Receive blocks until a message is available, ctx is done or the
+// subscription is closed.
+func (s *Subscription) Receive(ctx context.Context) (*Delivery, error) {
+	return s.s.receive(ctx, s.gen)
+}
+
+// Close detaches the consumer. In-flight deliveries are requeued; a
+// non-durable subscription is deleted.
+func (s *Subscription) Close() error {
+	err := errs.Wrap(errs.ErrClosed, "broker: subscription close")
+	s.once.Do(func() {
+		err = nil
+		if s.s.detach(s.gen) {
+			s.s.b.removeIfTransient(s.s)
+		}
+	})
+	return err
+}
+
+type pendingMsg struct {
+	msg     Message
+	attempt int
+}
+
+type inflightMsg struct {
+	pendingMsg
+	deadline time.Time
+}
+
+// subscription holds one subscription's messages.
+type subscription struct {
+	b       *Broker
+	topic   string
+	name    string
+	durable bool
+
+	mu       sync.Mutex
+	pending  []pendingMsg
+	inflight map[uint64]*inflightMsg
+	nextTag  uint64
+	attached bool
+	gen      uint64 // bumped on every attach
+	closed   bool
+	// changed is closed and replaced on every state change, waking
+	// publishers waiting for space and receivers waiting for messages.
+	changed chan struct{}
+}
+
+func newSubscription(b *Broker, topic, name string, durable bool) *subscription {
+	return &subscription{
+		b:        b,
+		topic:    topic,
+		name:     name,
+		durable:  durable,
+		inflight: make(map[uint64]*inflightMsg),
+		changed:  make(chan struct{}),
+	}
+}
+
+// notify wakes every waiter. s.mu must be held.
+func (s *subscription) notify() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// attach claims the subscription for a consumer and returns the
+// attachment's generation.
+func (s *subscription) attach() (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return 0, errs.ErrClosed
+	}
+	if s.attached {
+		return 0, errs.ErrConflict
+	}
+	s.attached = true
+	s.gen++
+	return s.gen, nil
+}
+
+// current reports whether attachment gen is still the attached consumer.
+// s.mu must be held.
+func (s *subscription) current(gen uint64) bool {
+	return s.attached && s.gen == gen
+}
+
+// detach releases attachment gen, reporting false if it had already been
+// replaced.
+func (s *subscription) detach(gen uint64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.current(gen) {
+		return false
+	}
+	s.attached = false
+	s.requeueAll()
+	s.notify()
+	return true
+}
+
+func (s *subscription) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.closed {
+		s.closed = true
+		s.notify()
+	}
+}
+
+func (s *subscription) push(ctx context.Context, msg Message) error {
+	s.mu.Lock()
+	for !s.closed && len(s.pending)+len(s.inflight) >= s.b.opts.BufferSize {
+		ch := s.changed
+		s.mu.Unlock()
+		select {
+		case <-ch:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		s.mu.Lock()
+	}
+	if s.closed {
+		s.mu.Unlock()
+		if s.b.isClosed() {
+			return errs.Wrap(errs.ErrClosed, "broker: publish")
+		}
+		// The subscription was deleted while we waited, so the message
+		// has nowhere to go and is not owed to anyone.
+		return nil
+	}
+	defer s.mu.Unlock()
+	s.pending = append(s.pending, pendingMsg{msg: msg, attempt: 1})
+	s.notify()
+	return nil
+}
+
+func (s *subscription) receive(ctx context.Context, gen uint64) (*Delivery, error) {
+	s.mu.Lock()
+	for {
+		if s.closed || !s.current(gen) {
+			s.mu.Unlock()
+			return nil, errs.Wrap(errs.ErrClosed, "broker: receive")
+		}
+		if len(s.pending) > 0 {
+			break
+		}
+		ch := s.changed
+		s.mu.Unlock()
+		select {
+		case <-ch:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		s.mu.Lock()
+	}
+	defer s.mu.Unlock()
+
+	p := s.pending[0]
+	s.pending[0] = pendingMsg{}
+	s.pending = s.pending[1:]
+	s.nextTag++
+	tag := s.nextTag
+	s.inflight[tag] = &inflightMsg{pendingMsg: p, deadline: time.Now().Add(s.b.opts.AckTimeout)}
+	return &Delivery{
+		Message: p.msg,
+		Tag:     tag,
+		Attempt: p.attempt,
+		settle:  func(ack, requeue bool) error { return s.settle(tag, ack, requeue) },
+	}, nil
+}
+
+func (s *subscription) settle(tag uint64, ack, requeue bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, ok := s.inflight[tag]
+	if !ok {
+		return errs.Wrap(errs.ErrNotFound, "broker: settle delivery")
+	}
+	delete(s.inflight, tag)
+	if !ack && requeue {
+		s.requeue(m.pendingMsg)
+	}
+	s.notify()
+	return nil
+}
+
+// requeue puts p back at the head of the queue for redelivery. s.mu must
+// be held.
+func (s *subscription) requeue(p pendingMsg) {
+	p.attempt++
+	s.pending = append([]pendingMsg{p}, s.pending...)
+}
+
+// requeueAll returns every in-flight message to the queue in id order.
+// s.mu must be held.
+func (s *subscription) requeueAll() {
+	if len(s.inflight) == 0 {
+		return
+	}
+	back := make([]pendingMsg, 0, len(s.inflight))
+	for tag, m := range s.inflight {
+		m.attempt++
+		back = append(back, m.pendingMsg)
+		delete(s.inflight, tag)
+	}
+	sortByID(back)
+	s.pending = append(back, s.pending...)
+}
+
+func (s *subscription) requeueExpired(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var back []pendingMsg
+	for tag, m := range s.inflight {
+		if now.After(m.deadline) {
+			m.attempt++
+			back = append(back, m.pendingMsg)
+			delete(s.inflight, tag)
+		}
+	}
+	if len(back) == 0 {
+		return
+	}
+	sortByID(back)
+	s.pending = append(back, s.pending...)
+	s.notify()
+}
+
+func (s *subscription) stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return Stats{
+		Topic:    s.topic,
+		Name:     s.name,
+		Durable:  s.durable,
+		Attached: s.attached,
+		Pending:  len(s.pending),
+		InFlight: len(s.inflight),
+	}
+}
+
+func sortByID(ps []pendingMsg) {
+	sort.Slice(ps, func(i, j int) bool { return ps[i].msg.ID < ps[j].msg.ID })
+}
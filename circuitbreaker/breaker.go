@@ -0,0 +1,317 @@
+// Package circuitbreaker stops calling a failing dependency for a while
+// instead of piling more load onto it. A Breaker is closed while the
+// failure and slow-call rates over its recent calls stay under their
+// thresholds, opens when either is crossed, and after a timeout lets a few
+// trial calls through (half-open) to decide whether to close again.
+package circuitbreaker
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrOpen is returned while the breaker is open.
+	ErrOpen = errors.New("circuitbreaker: breaker is open")
+	// ErrTooManyRequests is returned in the half-open state once every
+	// trial call is in use.
+	ErrTooManyRequests = errors.New("circuitbreaker: too many requests")
+)
+
+// State is the breaker state.
+type State int
+
+const (
+	StateClosed State = iota
+	StateOpen
+	StateHalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	}
+	return fmt.Sprintf("State(%d)", int(s))
+}
+
+// Result describes one finished or rejected call, for metrics hooks.
+type Result struct {
+	Duration time.Duration
+	Failure  bool
+	Slow     bool
+	Rejected bool
+}
+
+// Settings configures a Breaker. Zero fields take the documented defaults.
+type Settings struct {
+	Name string
+	// WindowSize is how many recent calls the rates are computed over. Default 20.
+	WindowSize int
+	// MinimumCalls must be recorded before the breaker may open. Default 10.
+	MinimumCalls int
+	// FailureRateThreshold in (0, 1] opens the breaker. Default 0.5.
+	FailureRateThreshold float64
+	// SlowCallDuration marks calls taking at least this long as slow. Zero
+	// disables slow-call tracking.
+	SlowCallDuration time.Duration
+	// SlowCallRateThreshold in (0, 1] opens the breaker. Default 1.
+	SlowCallRateThreshold float64
+	// OpenTimeout is how long the breaker stays open. Default 30s.
+	OpenTimeout time.Duration
+	// HalfOpenMaxCalls trial calls must all succeed to close. Default 1.
+	HalfOpenMaxCalls int
+	// IsFailure decides whether an error counts as a failure. Default
+	// err != nil. A call that panics always counts as a failure.
+	IsFailure func(err error) bool
+	// OnStateChange is called, without the breaker lock held, on every transition.
+	OnStateChange func(name string, from, to State)
+	// OnResult is called after every call, including rejected ones.
+	OnResult func(name string, r Result)
+}
+
+func (s *Settings) setDefaults() {
+	if s.WindowSize <= 0 {
+		s.WindowSize = 20
+	}
+	if s.MinimumCalls <= 0 {
+		s.MinimumCalls = 10
+	}
+	if s.MinimumCalls > s.WindowSize {
+		s.MinimumCalls = s.WindowSize
+	}
+	if s.FailureRateThreshold <= 0 || s.FailureRateThreshold > 1 {
+		s.FailureRateThreshold = 0.5
+	}
+	if s.SlowCallRateThreshold <= 0 || s.SlowCallRateThreshold > 1 {
+		s.SlowCallRateThreshold = 1
+	}
+	if s.OpenTimeout <= 0 {
+		s.OpenTimeout = 30 * time.Second
+	}
+	if s.HalfOpenMaxCalls <= 0 {
+		s.HalfOpenMaxCalls = 1
+	}
+	if s.IsFailure == nil {
+		s.IsFailure = func(err error) bool { return err != nil }
+	}
+}
+
+// Counts is a snapshot of the breaker's sliding window.
+type Counts struct {
+	Calls    int
+	Failures int
+	Slow     int
+}
+
+// Breaker is a circuit breaker. It is safe for concurrent use.
+type Breaker struct {
+	s   Settings
+	now func() time.Time
+
+	mu         sync.Mutex
+	state      State
+	generation uint64 // bumped on every transition; stale results are ignored
+	openedAt   time.Time
+	window     []outcome
+	next       int
+	counts     Counts
+	trials     int // half-open calls admitted
+	successes  int // half-open calls succeeded
+}
+
+type outcome struct {
+	failure bool
+	slow    bool
+}
+
+// New returns a closed Breaker.
+func New(s Settings) *Breaker {
+	s.setDefaults()
+	return &Breaker{s: s, now: time.Now, window: make([]outcome, 0, s.WindowSize)}
+}
+
+// Name returns the breaker's name.
+func (b *Breaker) Name() string { return b.s.Name }
+
+// State returns the current state, moving from open to half-open if the
+// open timeout has passed.
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	st, change := b.currentState(b.now())
+	b.mu.Unlock()
+	b.notify(change)
+	return st
+}
+
+// Counts returns a snapshot of the current window.
+func (b *Breaker) Counts() Counts {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.counts
+}
+
+// errPanic stands in for the error of a call that panicked.
+var errPanic = errors.New("circuitbreaker: call panicked")
+
+// Execute runs fn if the breaker allows it and records the outcome. A
+// panic in fn is recorded as a failure and then re-raised.
+func (b *Breaker) Execute(fn func() error) (err error) {
+	done, err := b.Allow()
+	if err != nil {
+		return err
+	}
+	panicked := true
+	defer func() {
+		if panicked {
+			done(errPanic)
+		}
+	}()
+	err = fn()
+	panicked = false
+	done(err)
+	return err
+}
+
+// Call is Execute for functions returning a value.
+func Call[T any](b *Breaker, fn func() (T, error)) (T, error) {
+	var v T
+	err := b.Execute(func() error {
+		var err error
+		v, err = fn()
+		return err
+	})
+	return v, err
+}
+
+// Allow reports whether a call may proceed. On success the caller must
+// invoke done exactly once with the call's error, on every path including
+// panics: in the half-open state an unfinished call keeps its trial slot,
+// and the breaker rejects everything until done is called.
+func (b *Breaker) Allow() (done func(err error), err error) {
+	now := b.now()
+	b.mu.Lock()
+	st, change := b.currentState(now)
+	switch st {
+	case StateOpen:
+		err = ErrOpen
+	case StateHalfOpen:
+		if b.trials >= b.s.HalfOpenMaxCalls {
+			err = ErrTooManyRequests
+		} else {
+			b.trials++
+		}
+	}
+	gen := b.generation
+	b.mu.Unlock()
+	b.notify(change)
+
+	if err != nil {
+		if b.s.OnResult != nil {
+			b.s.OnResult(b.s.Name, Result{Rejected: true})
+		}
+		return nil, err
+	}
+	return func(err error) { b.record(gen, now, err) }, nil
+}
+
+func (b *Breaker) record(gen uint64, start time.Time, err error) {
+	d := b.now().Sub(start)
+	o := outcome{
+		failure: errors.Is(err, errPanic) || b.s.IsFailure(err),
+		slow:    b.s.SlowCallDuration > 0 && d >= b.s.SlowCallDuration,
+	}
+
+	b.mu.Lock()
+	var change *transition
+	if gen == b.generation {
+		switch b.state {
+		case StateClosed:
+			b.push(o)
+			if b.tripped() {
+				change = b.setState(StateOpen, b.now())
+			}
+		case StateHalfOpen:
+			if o.failure || o.slow {
+				change = b.setState(StateOpen, b.now())
+			} else if b.successes++; b.successes >= b.s.HalfOpenMaxCalls {
+				change = b.setState(StateClosed, b.now())
+			}
+		}
+	}
+	b.mu.Unlock()
+	b.notify(change)
+
+	if b.s.OnResult != nil {
+		b.s.OnResult(b.s.Name, Result{Duration: d, Failure: o.failure, Slow: o.slow})
+	}
+}
+
+func (b *Breaker) push(o outcome) {
+	if len(b.window) < b.s.WindowSize {
+		b.window = append(b.window, o)
+	} else {
+		old := b.window[b.next]
+		b.counts.Calls--
+		if old.failure {
+			b.counts.Failures--
+		}
+		if old.slow {
+			b.counts.Slow--
+		}
+		b.window[b.next] = o
+		b.next = (b.next + 1) % b.s.WindowSize
+	}
+	b.counts.Calls++
+	if o.failure {
+		b.counts.Failures++
+	}
+	if o.slow {
+		b.counts.Slow++
+	}
+}
+
+func (b *Breaker) tripped() bool {
+	c := b.counts
+	if c.Calls < b.s.MinimumCalls {
+		return false
+	}
+	n := float64(c.Calls)
+	return float64(c.Failures)/n >= b.s.FailureRateThreshold ||
+		(b.s.SlowCallDuration > 0 && float64(c.Slow)/n >= b.s.SlowCallRateThreshold)
+}
+
+type transition struct{ from, to State }
+
+func (b *Breaker) currentState(now time.Time) (State, *transition) {
+	if b.state == StateOpen && !now.Before(b.openedAt.Add(b.s.OpenTimeout)) {
+		return StateHalfOpen, b.setState(StateHalfOpen, now)
+	}
+	return b.state, nil
+}
+
+func (b *Breaker) setState(to State, now time.Time) *transition {
+	from := b.state
+	b.state = to
+	b.generation++
+	b.window = b.window[:0]
+	b.next = 0
+	b.counts = Counts{}
+	b.trials, b.successes = 0, 0
+	if to == StateOpen {
+		b.openedAt = now
+	}
+	return &transition{from, to}
+}
+
+func (b *Breaker) notify(t *transition) {
+	if t != nil && b.s.OnStateChange != nil {
+		b.s.OnStateChange(b.s.Name, t.from, t.to)
+	}
+}
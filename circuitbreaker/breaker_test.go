@@ -0,0 +1,220 @@
+package circuitbreaker
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var errBoom = errors.New("boom")
+
+// clock is a manual time source installed through Breaker.now.
+type clock struct{ t time.Time }
+
+func (c *clock) now() time.Time          { return c.t }
+func (c *clock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func newTestBreaker(s Settings) (*Breaker, *clock, *[]string) {
+	c := &clock{t: time.Unix(0, 0)}
+	var transitions []string
+	s.OnStateChange = func(_ string, from, to State) {
+		transitions = append(transitions, from.String()+">"+to.String())
+	}
+	b := New(s)
+	b.now = c.now
+	return b, c, &transitions
+}
+
+func fail() error    { return errBoom }
+func succeed() error { return nil }
+
+func TestOpensOnFailureRate(t *testing.T) {
+	b, _, _ := newTestBreaker(Settings{WindowSize: 4, MinimumCalls: 4, FailureRateThreshold: 0.5})
+	b.Execute(fail)
+	b.Execute(succeed)
+	b.Execute(succeed)
+	if b.State() != StateClosed {
+		t.Fatal("opened before MinimumCalls")
+	}
+	b.Execute(fail)
+	if b.State() != StateOpen {
+		t.Fatalf("state = %v at 50%% failures, want open", b.State())
+	}
+	if err := b.Execute(succeed); !errors.Is(err, ErrOpen) {
+		t.Errorf("Execute while open = %v, want ErrOpen", err)
+	}
+}
+
+func TestWindowSlides(t *testing.T) {
+	b, _, _ := newTestBreaker(Settings{WindowSize: 4, MinimumCalls: 4, FailureRateThreshold: 0.75})
+	b.Execute(fail)
+	b.Execute(fail)
+	for i := 0; i < 4; i++ {
+		b.Execute(succeed)
+	}
+	b.Execute(fail)
+	b.Execute(fail)
+	// The first two failures have slid out: 2 of the last 4 failed.
+	if b.State() != StateClosed {
+		t.Fatalf("state = %v, want closed", b.State())
+	}
+	if c := b.Counts(); c != (Counts{Calls: 4, Failures: 2}) {
+		t.Errorf("Counts = %+v", c)
+	}
+}
+
+func TestHalfOpenCloses(t *testing.T) {
+	b, clk, transitions := newTestBreaker(Settings{WindowSize: 2, MinimumCalls: 2, OpenTimeout: time.Second, HalfOpenMaxCalls: 2})
+	b.Execute(fail)
+	b.Execute(fail)
+	clk.advance(999 * time.Millisecond)
+	if b.State() != StateOpen {
+		t.Fatal("left open state before the timeout")
+	}
+	clk.advance(time.Millisecond)
+
+	d1, err1 := b.Allow()
+	d2, err2 := b.Allow()
+	if err1 != nil || err2 != nil {
+		t.Fatalf("trial calls refused: %v, %v", err1, err2)
+	}
+	if _, err := b.Allow(); !errors.Is(err, ErrTooManyRequests) {
+		t.Fatalf("third trial = %v, want ErrTooManyRequests", err)
+	}
+	d1(nil)
+	if b.State() != StateHalfOpen {
+		t.Fatal("closed before every trial finished")
+	}
+	d2(nil)
+	want := []string{"closed>open", "open>half-open", "half-open>closed"}
+	if !reflect.DeepEqual(*transitions, want) {
+		t.Errorf("transitions = %v, want %v", *transitions, want)
+	}
+}
+
+func TestHalfOpenReopensOnFailure(t *testing.T) {
+	b, clk, _ := newTestBreaker(Settings{WindowSize: 2, MinimumCalls: 2, OpenTimeout: time.Second})
+	b.Execute(fail)
+	b.Execute(fail)
+	clk.advance(time.Second)
+	b.Execute(fail)
+	if b.State() != StateOpen {
+		t.Fatalf("state = %v after failed trial, want open", b.State())
+	}
+}
+
+func TestSlowCallsOpen(t *testing.T) {
+	b, clk, _ := newTestBreaker(Settings{
+		WindowSize: 3, MinimumCalls: 3,
+		SlowCallDuration: 100 * time.Millisecond, SlowCallRateThreshold: 0.6,
+	})
+	slow := func() error { clk.advance(150 * time.Millisecond); return nil }
+	b.Execute(slow)
+	b.Execute(succeed)
+	b.Execute(slow)
+	if b.State() != StateOpen {
+		t.Fatalf("state = %v with 2/3 slow calls, want open", b.State())
+	}
+}
+
+func TestStaleResultsIgnored(t *testing.T) {
+	b, _, _ := newTestBreaker(Settings{WindowSize: 2, MinimumCalls: 2})
+	late, _ := b.Allow()
+	b.Execute(fail)
+	b.Execute(fail)
+	late(errBoom)
+	if c := b.Counts(); c.Calls != 0 {
+		t.Errorf("result from before the transition was recorded: %+v", c)
+	}
+}
+
+func TestPanicCountsAsFailure(t *testing.T) {
+	tests := []struct {
+		name      string
+		isFailure func(error) bool
+	}{
+		{"default", nil},
+		// A selective IsFailure must not let panics through.
+		{"custom IsFailure", func(err error) bool { return errors.Is(err, errBoom) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, clk, _ := newTestBreaker(Settings{
+				WindowSize: 1, MinimumCalls: 1, OpenTimeout: time.Second,
+				IsFailure: tt.isFailure,
+			})
+			b.Execute(fail)
+			clk.advance(time.Second)
+
+			func() {
+				defer func() {
+					if recover() == nil {
+						t.Fatal("panic was swallowed")
+					}
+				}()
+				b.Execute(func() error { panic("trial") })
+			}()
+			if b.State() != StateOpen {
+				t.Fatalf("state = %v after panicking trial, want open", b.State())
+			}
+			clk.advance(time.Second)
+			if err := b.Execute(succeed); err != nil {
+				t.Fatalf("breaker did not recover after a panic: %v", err)
+			}
+			if b.State() != StateClosed {
+				t.Fatalf("state = %v, want closed", b.State())
+			}
+
+			func() {
+				defer func() { recover() }()
+				b.Execute(func() error { panic("closed") })
+			}()
+			if b.State() != StateOpen {
+				t.Errorf("state = %v after panic while closed, want open", b.State())
+			}
+		})
+	}
+}
+
+func TestIsFailureAndOnResult(t *testing.T) {
+	var results []Result
+	b, _, _ := newTestBreaker(Settings{
+		WindowSize: 2, MinimumCalls: 2,
+		IsFailure: func(err error) bool { return err != nil && !errors.Is(err, errBoom) },
+		OnResult:  func(_ string, r Result) { results = append(results, r) },
+	})
+	b.Execute(fail)
+	b.Execute(fail)
+	if b.State() != StateClosed {
+		t.Fatal("ignored errors opened the breaker")
+	}
+	if len(results) != 2 || results[0].Failure {
+		t.Errorf("results = %+v", results)
+	}
+}
+
+func TestCall(t *testing.T) {
+	b := New(Settings{})
+	v, err := Call(b, func() (int, error) { return 42, nil })
+	if v != 42 || err != nil {
+		t.Errorf("Call = %d, %v", v, err)
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry(func(string) Settings { return Settings{WindowSize: 1, MinimumCalls: 1} })
+	if r.Get("a") != r.Get("a") {
+		t.Fatal("Get returned different breakers for one name")
+	}
+	r.Execute("b", fail)
+	if got, want := r.Names(), []string{"a", "b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Names = %v, want %v", got, want)
+	}
+	if got := r.States(); got["a"] != StateClosed || got["b"] != StateOpen {
+		t.Errorf("States = %v", got)
+	}
+	if r.Get("b").Name() != "b" {
+		t.Error("registry did not set the breaker name")
+	}
+}
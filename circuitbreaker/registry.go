@@ -0,0 +1,68 @@
+package circuitbreaker
+
+import (
+	"sort"
+	"sync"
+)
+
+// Registry hands out one Breaker per name, e.g. one per remote host or
+// webhook endpoint, creating each on first use.
+type Registry struct {
+	settings func(name string) Settings
+
+	mu       sync.Mutex
+	breakers map[string]*Breaker
+}
+
+// NewRegistry returns a Registry building breakers from settings. The
+// returned Settings' Name is always overwritten with name.
+func NewRegistry(settings func(name string) Settings) *Registry {
+	return &Registry{settings: settings, breakers: make(map[string]*Breaker)}
+}
+
+// Get returns the breaker for name, creating it if needed.
+func (r *Registry) Get(name string) *Breaker {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	b, ok := r.breakers[name]
+	if !ok {
+		s := r.settings(name)
+		s.Name = name
+		b = New(s)
+		r.breakers[name] = b
+	}
+	return b
+}
+
+// Execute runs fn through the breaker for name.
+func (r *Registry) Execute(name string, fn func() error) error {
+	return r.Get(name).Execute(fn)
+}
+
+// States returns the current state of every breaker, keyed by name.
+func (r *Registry) States() map[string]State {
+	r.mu.Lock()
+	bs := make([]*Breaker, 0, len(r.breakers))
+	for _, b := range r.breakers {
+		bs = append(bs, b)
+	}
+	r.mu.Unlock()
+
+	out := make(map[string]State, len(bs))
+	for _, b := range bs {
+		out[b.Name()] = b.State()
+	}
+	return out
+}
+
+// Names returns the registered breaker names in sorted order.
+func (r *Registry) Names() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make([]string, 0, len(r.breakers))
+	for name := range r.breakers {
+		out = append(out, name)
+	}
+	sort.Strings(out)
+	return out
+}
@@ -0,0 +1,211 @@
+// Package config loads settings from defaults, an optional YAML file,
+// environment variables and command-line flags, in that order of
+// precedence (later sources win), and validates the result.
+//
+// A setting registered as "db-dsn" on a loader named "todo" is read from
+// the YAML key "db-dsn" (or "db_dsn"), the environment variable
+// TODO_DB_DSN and the flag -db-dsn. The YAML file is taken from the
+// -config flag or the TODO_CONFIG environment variable.
+package config
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type field struct {
+	name    string
+	def     string // default, for flag usage text only
+	set     func(string) error
+	isBool  bool
+	flagVal string
+	flagSet bool
+}
+
+// flagValue defers applying a flag until Load has merged every source.
+type flagValue struct{ f *field }
+
+func (v flagValue) String() string {
+	if v.f == nil {
+		return ""
+	}
+	return v.f.def
+}
+
+func (v flagValue) Set(s string) error {
+	v.f.flagVal, v.f.flagSet = s, true
+	return nil
+}
+
+func (v flagValue) IsBoolFlag() bool { return v.f != nil && v.f.isBool }
+
+// Loader collects settings and loads them from every source.
+type Loader struct {
+	name       string
+	fs         *flag.FlagSet
+	fields     []*field
+	validators []func() error
+	file       string
+	lookupEnv  func(string) (string, bool)
+}
+
+// New returns a Loader whose environment variables are prefixed with the
+// upper-cased name.
+func New(name string) *Loader {
+	l := &Loader{
+		name:      name,
+		fs:        flag.NewFlagSet(name, flag.ContinueOnError),
+		lookupEnv: os.LookupEnv,
+	}
+	l.fs.StringVar(&l.file, "config", "", "path to a YAML config file")
+	return l
+}
+
+// FlagSet exposes the underlying flag set, e.g. to customise Usage.
+func (l *Loader) FlagSet() *flag.FlagSet { return l.fs }
+
+// Var registers a setting of any type parsed by parse. The name "config"
+// is reserved for the config file path; registering it, or any name
+// twice, panics.
+func Var[T any](l *Loader, p *T, name string, def T, usage string, parse func(string) (T, error)) {
+	if name == "config" {
+		panic(`config: setting name "config" is reserved`)
+	}
+	*p = def
+	f := &field{
+		name: name,
+		def:  fmt.Sprint(def),
+		set: func(s string) error {
+			v, err := parse(s)
+			if err != nil {
+				return err
+			}
+			*p = v
+			return nil
+		},
+	}
+	_, f.isBool = any(def).(bool)
+	l.fields = append(l.fields, f)
+	l.fs.Var(flagValue{f}, name, usage)
+}
+
+// String registers a string setting.
+func (l *Loader) String(p *string, name, def, usage string) {
+	Var(l, p, name, def, usage, func(s string) (string, error) { return s, nil })
+}
+
+// Int registers an int setting.
+func (l *Loader) Int(p *int, name string, def int, usage string) {
+	Var(l, p, name, def, usage, strconv.Atoi)
+}
+
+// Float64 registers a float64 setting.
+func (l *Loader) Float64(p *float64, name string, def float64, usage string) {
+	Var(l, p, name, def, usage, func(s string) (float64, error) { return strconv.ParseFloat(s, 64) })
+}
+
+// Bool registers a bool setting.
+func (l *Loader) Bool(p *bool, name string, def bool, usage string) {
+	Var(l, p, name, def, usage, strconv.ParseBool)
+}
+
+// Duration registers a time.Duration setting.
+func (l *Loader) Duration(p *time.Duration, name string, def time.Duration, usage string) {
+	Var(l, p, name, def, usage, time.ParseDuration)
+}
+
+// Validate adds a check run after all sources are loaded.
+func (l *Loader) Validate(fn func() error) {
+	l.validators = append(l.validators, fn)
+}
+
+// Load parses args (usually os.Args[1:]), merges every source and runs
+// the validators.
+func (l *Loader) Load(args []string) error {
+	if err := l.fs.Parse(args); err != nil {
+		return err
+	}
+
+	path := l.file
+	if path == "" {
+		path, _ = l.lookupEnv(l.envName("config"))
+	}
+	var file map[string]string
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("config: %w", err)
+		}
+		if file, err = parseYAML(data); err != nil {
+			return fmt.Errorf("config: %s: %w", path, err)
+		}
+		if err := l.checkKeys(file); err != nil {
+			return fmt.Errorf("config: %s: %w", path, err)
+		}
+	}
+
+	for _, f := range l.fields {
+		var val, src string
+		if v, ok := lookupFile(file, f.name); ok {
+			val, src = v, "file"
+		}
+		if v, ok := l.lookupEnv(l.envName(f.name)); ok {
+			val, src = v, "env "+l.envName(f.name)
+		}
+		if f.flagSet {
+			val, src = f.flagVal, "flag"
+		}
+		if src == "" {
+			continue // keep the default Var stored
+		}
+		if err := f.set(val); err != nil {
+			return fmt.Errorf("config: %s (from %s): %w", f.name, src, err)
+		}
+	}
+
+	for _, fn := range l.validators {
+		if err := fn(); err != nil {
+			return fmt.Errorf("config: %w", err)
+		}
+	}
+	return nil
+}
+
+func (l *Loader) envName(name string) string {
+	r := strings.NewReplacer("-", "_", ".", "_")
+	return strings.ToUpper(r.Replace(l.name + "_" + name))
+}
+
+// checkKeys rejects file keys that match no registered setting, so a
+// typo in a config file is reported rather than silently ignored.
+func (l *Loader) checkKeys(file map[string]string) error {
+	known := make(map[string]bool, 2*len(l.fields))
+	for _, f := range l.fields {
+		known[f.name] = true
+		known[strings.ReplaceAll(f.name, "-", "_")] = true
+	}
+	var unknown []string
+	for k := range file {
+		if !known[k] {
+			unknown = append(unknown, k)
+		}
+	}
+	if len(unknown) == 0 {
+		return nil
+	}
+	sort.Strings(unknown)
+	return fmt.Errorf("unknown keys: %s", strings.Join(unknown, ", "))
+}
+
+func lookupFile(file map[string]string, name string) (string, bool) {
+	if v, ok := file[name]; ok {
+		return v, true
+	}
+	v, ok := file[strings.ReplaceAll(name, "-", "_")]
+	return v, ok
+}
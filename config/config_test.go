@@ -0,0 +1,150 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type settings struct {
+	addr    string
+	workers int
+	ratio   float64
+	debug   bool
+	timeout time.Duration
+	tags    []string
+}
+
+func newLoader(env map[string]string) (*Loader, *settings) {
+	l := New("todo")
+	l.lookupEnv = func(k string) (string, bool) {
+		v, ok := env[k]
+		return v, ok
+	}
+	s := &settings{}
+	l.String(&s.addr, "addr", ":8080", "listen address")
+	l.Int(&s.workers, "workers", 4, "worker count")
+	l.Float64(&s.ratio, "ratio", 0.5, "sample ratio")
+	l.Bool(&s.debug, "debug", false, "debug mode")
+	l.Duration(&s.timeout, "read-timeout", time.Second, "read timeout")
+	Var(l, &s.tags, "tags", []string{"a", "b"}, "comma-separated tags",
+		func(v string) ([]string, error) { return strings.Split(v, ","), nil })
+	return l, s
+}
+
+func writeFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestDefaults(t *testing.T) {
+	l, s := newLoader(nil)
+	if err := l.Load(nil); err != nil {
+		t.Fatal(err)
+	}
+	want := settings{":8080", 4, 0.5, false, time.Second, []string{"a", "b"}}
+	if !reflect.DeepEqual(*s, want) {
+		t.Errorf("got %+v, want %+v", *s, want)
+	}
+}
+
+func TestPrecedence(t *testing.T) {
+	path := writeFile(t, "addr: :1\nworkers: 2\nratio: 0.1\nread_timeout: 2s\n")
+	env := map[string]string{"TODO_WORKERS": "3", "TODO_RATIO": "0.2"}
+	l, s := newLoader(env)
+	if err := l.Load([]string{"-config", path, "-ratio", "0.3", "-tags", "x,y"}); err != nil {
+		t.Fatal(err)
+	}
+	want := settings{":1", 3, 0.3, false, 2 * time.Second, []string{"x", "y"}}
+	if !reflect.DeepEqual(*s, want) {
+		t.Errorf("got %+v, want %+v", *s, want)
+	}
+}
+
+func TestConfigPathFromEnv(t *testing.T) {
+	path := writeFile(t, "workers: 9\n")
+	l, s := newLoader(map[string]string{"TODO_CONFIG": path})
+	if err := l.Load(nil); err != nil {
+		t.Fatal(err)
+	}
+	if s.workers != 9 {
+		t.Errorf("workers = %d, want 9", s.workers)
+	}
+}
+
+func TestBoolFlag(t *testing.T) {
+	l, s := newLoader(map[string]string{"TODO_DEBUG": "false"})
+	if err := l.Load([]string{"-debug", "-workers", "1"}); err != nil {
+		t.Fatal(err)
+	}
+	if !s.debug || s.workers != 1 {
+		t.Errorf("debug = %v, workers = %d", s.debug, s.workers)
+	}
+
+	l, s = newLoader(nil)
+	if err := l.Load([]string{"-debug=false"}); err != nil || s.debug {
+		t.Errorf("-debug=false: debug = %v, err = %v", s.debug, err)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		env  map[string]string
+		args []string
+		want string
+	}{
+		{"bad env", "", map[string]string{"TODO_WORKERS": "many"}, nil, "workers (from env TODO_WORKERS)"},
+		{"bad flag", "", nil, []string{"-read-timeout", "soon"}, "read-timeout (from flag)"},
+		{"bad file value", "ratio: half\n", nil, nil, "ratio (from file)"},
+		{"unknown keys", "wrokers: 2\naddr: :1\nzz: 1\n", nil, nil, "unknown keys: wrokers, zz"},
+		{"bad yaml", "addr\n", nil, nil, "line 1"},
+		{"missing file", "", nil, []string{"-config", "/nonexistent/config.yaml"}, "no such file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := tt.args
+			if tt.file != "" {
+				args = append([]string{"-config", writeFile(t, tt.file)}, args...)
+			}
+			l, _ := newLoader(tt.env)
+			err := l.Load(args)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Load = %v, want error containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	errTooMany := errors.New("too many workers")
+	l, s := newLoader(map[string]string{"TODO_WORKERS": "100"})
+	l.Validate(func() error {
+		if s.workers > 10 {
+			return errTooMany
+		}
+		return nil
+	})
+	if err := l.Load(nil); !errors.Is(err, errTooMany) {
+		t.Errorf("Load = %v, want %v", err, errTooMany)
+	}
+}
+
+func TestReservedName(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error(`registering "config" did not panic`)
+		}
+	}()
+	var s string
+	New("todo").String(&s, "config", "", "")
+}
@@ -0,0 +1,57 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseYAML(t *testing.T) {
+	in := `---
+# comment
+plain: hello world
+spaced:   value   # trailing comment
+hash: a#b
+empty:
+double: "a \"b\" # not a comment"
+double_comment: "x"   # it's fine
+single: 'it''s'
+single_comment: 'a' # it's
+colon: http://host:80
+`
+	want := map[string]string{
+		"plain":          "hello world",
+		"spaced":         "value",
+		"hash":           "a#b",
+		"empty":          "",
+		"double":         `a "b" # not a comment`,
+		"double_comment": "x",
+		"single":         "it's",
+		"single_comment": "a",
+		"colon":          "http://host:80",
+	}
+	got, err := parseYAML([]byte(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q\nwant %q", got, want)
+	}
+}
+
+func TestParseYAMLErrors(t *testing.T) {
+	for _, in := range []string{
+		"key\n",
+		": value\n",
+		"parent:\n  child: 1\n",
+		`name: "unterminated` + "\n",
+		"name: 'unterminated\n",
+		"name: 'it''s\n",
+		`name: "a" garbage` + "\n",
+		"name: 'a' garbage\n",
+		`name: "\q"` + "\n",
+	} {
+		if _, err := parseYAML([]byte(in)); err == nil {
+			t.Errorf("parseYAML(%q) succeeded", in)
+		}
+	}
+}
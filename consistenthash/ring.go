@@ -0,0 +1,168 @@
+// Package consistenthash implements a hash ring with virtual nodes, so
+// keys can be spread over a changing set of nodes while only about 1/n of
+// them move when a node joins or leaves.
+package consistenthash
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// Hash maps bytes to a position on the ring.
+type Hash func(data []byte) uint64
+
+// DefaultReplicas is the number of virtual nodes per node used when New is
+// given a non-positive count.
+const DefaultReplicas = 160
+
+// Ring is a consistent hash ring. It is safe for concurrent use.
+type Ring struct {
+	hash     Hash
+	replicas int
+
+	mu     sync.RWMutex
+	vnodes []vnode // sorted by hash, then node
+	nodes  map[string]struct{}
+}
+
+type vnode struct {
+	hash uint64
+	node string
+}
+
+// New returns an empty ring placing replicas virtual nodes per node. A nil
+// hash uses FNV-1a with a 64-bit finalizer.
+func New(replicas int, hash Hash) *Ring {
+	if replicas <= 0 {
+		replicas = DefaultReplicas
+	}
+	if hash == nil {
+		hash = defaultHash
+	}
+	return &Ring{hash: hash, replicas: replicas, nodes: make(map[string]struct{})}
+}
+
+// Add places nodes on the ring. Nodes already present are ignored.
+func (r *Ring) Add(nodes ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, node := range nodes {
+		if _, ok := r.nodes[node]; ok {
+			continue
+		}
+		r.nodes[node] = struct{}{}
+		for i := 0; i < r.replicas; i++ {
+			r.vnodes = append(r.vnodes, vnode{hash: r.hash([]byte(strconv.Itoa(i) + "#" + node)), node: node})
+		}
+	}
+	sort.Slice(r.vnodes, func(i, j int) bool {
+		if r.vnodes[i].hash != r.vnodes[j].hash {
+			return r.vnodes[i].hash < r.vnodes[j].hash
+		}
+		return r.vnodes[i].node < r.vnodes[j].node
+	})
+}
+
+// Remove takes nodes off the ring. Unknown nodes are ignored.
+func (r *Ring) Remove(nodes ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	removed := false
+	for _, node := range nodes {
+		if _, ok := r.nodes[node]; ok {
+			delete(r.nodes, node)
+			removed = true
+		}
+	}
+	if !removed {
+		return
+	}
+	kept := r.vnodes[:0]
+	for _, v := range r.vnodes {
+		if _, ok := r.nodes[v.node]; ok {
+			kept = append(kept, v)
+		}
+	}
+	r.vnodes = kept
+}
+
+// Get returns the node owning key, or "" if the ring is empty.
+func (r *Ring) Get(key string) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if len(r.vnodes) == 0 {
+		return ""
+	}
+	return r.vnodes[r.search(key)].node
+}
+
+// GetN returns up to n distinct nodes for key, walking the ring clockwise
+// from the key's position. The first is the same node Get returns; the
+// rest are the natural replica set.
+func (r *Ring) GetN(key string, n int) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if len(r.vnodes) == 0 || n <= 0 {
+		return nil
+	}
+	if n > len(r.nodes) {
+		n = len(r.nodes)
+	}
+	out := make([]string, 0, n)
+	seen := make(map[string]struct{}, n)
+	for i, start := 0, r.search(key); len(out) < n; i++ {
+		node := r.vnodes[(start+i)%len(r.vnodes)].node
+		if _, ok := seen[node]; ok {
+			continue
+		}
+		seen[node] = struct{}{}
+		out = append(out, node)
+	}
+	return out
+}
+
+// Nodes returns the nodes on the ring in sorted order.
+func (r *Ring) Nodes() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	out := make([]string, 0, len(r.nodes))
+	for node := range r.nodes {
+		out = append(out, node)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Len returns the number of nodes on the ring.
+func (r *Ring) Len() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.nodes)
+}
+
+// search returns the index of the first virtual node at or after key's
+// hash, wrapping around to 0.
+func (r *Ring) search(key string) int {
+	h := r.hash([]byte(key))
+	i := sort.Search(len(r.vnodes), func(i int) bool { return r.vnodes[i].hash >= h })
+	if i == len(r.vnodes) {
+		i = 0
+	}
+	return i
+}
+
+// defaultHash is FNV-1a followed by the murmur3 finalizer; FNV alone
+// spreads short, similar strings such as "3#node-a" poorly.
+func defaultHash(data []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(data)
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
@@ -0,0 +1,142 @@
+package consistenthash
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func keys(n int) []string {
+	out := make([]string, n)
+	for i := range out {
+		out[i] = fmt.Sprintf("key-%d", i)
+	}
+	return out
+}
+
+func TestEmptyRing(t *testing.T) {
+	r := New(0, nil)
+	if got := r.Get("a"); got != "" {
+		t.Errorf("Get on empty ring = %q, want empty", got)
+	}
+	if got := r.GetN("a", 3); got != nil {
+		t.Errorf("GetN on empty ring = %v, want nil", got)
+	}
+}
+
+func TestAddRemove(t *testing.T) {
+	r := New(10, nil)
+	r.Add("a", "b", "c")
+	r.Add("a")
+	if got, want := r.Nodes(), []string{"a", "b", "c"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Nodes = %v, want %v", got, want)
+	}
+	r.Remove("b", "missing")
+	if got, want := r.Nodes(), []string{"a", "c"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Nodes = %v, want %v", got, want)
+	}
+	for _, k := range keys(1000) {
+		if n := r.Get(k); n != "a" && n != "c" {
+			t.Fatalf("Get(%q) = %q after removing b", k, n)
+		}
+	}
+}
+
+func TestGetDeterministic(t *testing.T) {
+	a, b := New(50, nil), New(50, nil)
+	a.Add("x", "y", "z")
+	b.Add("z", "x", "y")
+	for _, k := range keys(1000) {
+		if a.Get(k) != b.Get(k) {
+			t.Fatalf("Get(%q) depends on insertion order", k)
+		}
+	}
+}
+
+func TestGetN(t *testing.T) {
+	r := New(0, nil)
+	r.Add("a", "b", "c", "d")
+	for _, k := range keys(200) {
+		got := r.GetN(k, 3)
+		if len(got) != 3 {
+			t.Fatalf("GetN(%q, 3) = %v", k, got)
+		}
+		if got[0] != r.Get(k) {
+			t.Fatalf("GetN(%q)[0] = %q, Get = %q", k, got[0], r.Get(k))
+		}
+		seen := map[string]bool{}
+		for _, n := range got {
+			if seen[n] {
+				t.Fatalf("GetN(%q) = %v has duplicates", k, got)
+			}
+			seen[n] = true
+		}
+	}
+	if got := r.GetN("k", 10); len(got) != 4 {
+		t.Errorf("GetN beyond node count = %v, want all 4 nodes", got)
+	}
+}
+
+func TestDistribution(t *testing.T) {
+	const nodes, n = 8, 200_000
+	r := New(0, nil)
+	for i := 0; i < nodes; i++ {
+		r.Add(fmt.Sprintf("node-%d", i))
+	}
+	counts := map[string]int{}
+	for _, k := range keys(n) {
+		counts[r.Get(k)]++
+	}
+	mean := float64(n) / nodes
+	var ss float64
+	for _, c := range counts {
+		ss += (float64(c) - mean) * (float64(c) - mean)
+		if dev := math.Abs(float64(c)-mean) / mean; dev > 0.15 {
+			t.Errorf("node share off the mean by %.1f%%", 100*dev)
+		}
+	}
+	if cv := math.Sqrt(ss/nodes) / mean; cv > 0.08 {
+		t.Errorf("coefficient of variation = %.3f, want <= 0.08 (%v)", cv, counts)
+	}
+}
+
+func TestRemoveMovesOnlyThatNodesKeys(t *testing.T) {
+	r := New(0, nil)
+	r.Add("a", "b", "c", "d", "e")
+	ks := keys(50_000)
+	before := make(map[string]string, len(ks))
+	for _, k := range ks {
+		before[k] = r.Get(k)
+	}
+	r.Remove("c")
+	moved := 0
+	for _, k := range ks {
+		if got := r.Get(k); got != before[k] {
+			moved++
+			if before[k] != "c" {
+				t.Fatalf("key %q moved from %q to %q", k, before[k], got)
+			}
+		}
+	}
+	// About 1/5 of the keys lived on c.
+	if frac := float64(moved) / float64(len(ks)); frac < 0.15 || frac > 0.25 {
+		t.Errorf("moved %.1f%% of keys, want about 20%%", 100*frac)
+	}
+}
+
+func TestAddMovesOnlyToNewNode(t *testing.T) {
+	r := New(0, nil)
+	r.Add("a", "b", "c", "d")
+	ks := keys(50_000)
+	before := make(map[string]string, len(ks))
+	for _, k := range ks {
+		before[k] = r.Get(k)
+	}
+	r.Add("e")
+	for _, k := range ks {
+		if got := r.Get(k); got != before[k] && got != "e" {
+			t.Fatalf("key %q moved from %q to %q", k, before[k], got)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+// Package errs holds the sentinel errors shared by the packages in this
+// repository, so callers can handle failures uniformly with errors.Is and
+// errors.As regardless of which package produced them.
+package errs
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrNotFound is returned when a requested key, item or resource does not exist.
+	ErrNotFound = errors.New("not found")
+	// ErrFull is returned when a bounded container cannot accept more items.
+	ErrFull = errors.New("full")
+	// ErrClosed is returned when operating on a closed resource.
+	ErrClosed = errors.New("closed")
+	// ErrConflict is returned when a write conflicts with the current state.
+	ErrConflict = errors.New("conflict")
+)
+
+// Error annotates an underlying error with the operation that failed and,
+// optionally, the key or resource it was operating on.
+type Error struct {
+	Op  string
+	Key string
+	Err error
+}
+
+func (e *Error) Error() string {
+	if e.Key == "" {
+		return fmt.Sprintf("%s: %v", e.Op, e.Err)
+	}
+	return fmt.Sprintf("%s %q: %v", e.Op, e.Key, e.Err)
+}
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// Wrap returns err annotated with op. It returns nil if err is nil.
+func Wrap(err error, op string) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Op: op, Err: err}
+}
+
+// WrapKey returns err annotated with op and key. It returns nil if err is nil.
+func WrapKey(err error, op, key string) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Op: op, Key: key, Err: err}
+}
+
+// Wrapf returns err annotated with a formatted message, preserving the
+// chain for errors.Is and errors.As. It returns nil if err is nil.
+func Wrapf(err error, format string, args ...any) error {
+	if err == nil {
+		return nil
+	}
+	return fmt.Errorf("%s: %w", fmt.Sprintf(format, args...), err)
+}
+
+// IsNotFound reports whether err is or wraps ErrNotFound.
+func IsNotFound(err error) bool { return errors.Is(err, ErrNotFound) }
+
+// IsFull reports whether err is or wraps ErrFull.
+func IsFull(err error) bool { return errors.Is(err, ErrFull) }
+
+// IsClosed reports whether err is or wraps ErrClosed.
+func IsClosed(err error) bool { return errors.Is(err, ErrClosed) }
+
+// IsConflict reports whether err is or wraps ErrConflict.
+func IsConflict(err error) bool { return errors.Is(err, ErrConflict) }
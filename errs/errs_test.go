@@ -0,0 +1,60 @@
+package errs
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestWrapNil(t *testing.T) {
+	if err := Wrap(nil, "op"); err != nil {
+		t.Errorf("Wrap(nil) = %v", err)
+	}
+	if err := WrapKey(nil, "op", "k"); err != nil {
+		t.Errorf("WrapKey(nil) = %v", err)
+	}
+	if err := Wrapf(nil, "op %d", 1); err != nil {
+		t.Errorf("Wrapf(nil) = %v", err)
+	}
+}
+
+func TestIsThroughWrappers(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		is   func(error) bool
+	}{
+		{"Wrap", Wrap(ErrNotFound, "get"), IsNotFound},
+		{"WrapKey", WrapKey(ErrFull, "put", "k"), IsFull},
+		{"Wrapf", Wrapf(ErrClosed, "read segment %d", 3), IsClosed},
+		{"nested", Wrapf(WrapKey(ErrConflict, "put", "k"), "batch"), IsConflict},
+	}
+	for _, tt := range tests {
+		if !tt.is(tt.err) {
+			t.Errorf("%s: %v does not match its sentinel", tt.name, tt.err)
+		}
+		if errors.Is(tt.err, errors.New("not found")) {
+			t.Errorf("%s: matched an unrelated error", tt.name)
+		}
+	}
+
+	var e *Error
+	if !errors.As(Wrapf(WrapKey(ErrFull, "put", "k"), "batch"), &e) || e.Key != "k" {
+		t.Errorf("errors.As through Wrapf = %+v", e)
+	}
+}
+
+func TestErrorFormat(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{Wrap(ErrNotFound, "cache: get"), "cache: get: not found"},
+		{WrapKey(ErrNotFound, "cache: get", "a b"), `cache: get "a b": not found`},
+		{Wrapf(ErrFull, "queue %s", "jobs"), "queue jobs: full"},
+	}
+	for _, tt := range tests {
+		if got := tt.err.Error(); got != tt.want {
+			t.Errorf("Error() = %q, want %q", got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,3 @@
+module github.com/shameerb/projects-golang
+
+go 1.22
@@ -0,0 +1,183 @@
+package kvstore
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/shameerb/projects-golang/errs"
+)
+
+// Compact rewrites every sealed segment into one, keeping only the
+// records the index still points at, and removes the originals. Reads and
+// writes continue while the live records are copied.
+//
+// The rewrite is written to <id>.compact.tmp and renamed to <id>.compact
+// once synced, where id is the newest sealed segment. From that point the
+// compaction is committed: the sealed segments are deleted and the file is
+// renamed to <id>.log, and Open finishes those steps if a crash
+// interrupts them.
+func (db *DB) Compact() error {
+	db.compactMu.Lock()
+	defer db.compactMu.Unlock()
+
+	db.mu.Lock()
+	if db.closed {
+		db.mu.Unlock()
+		return errs.Wrap(errs.ErrClosed, "kvstore: compact")
+	}
+	if db.active.size > 0 {
+		if err := db.rotate(); err != nil {
+			db.mu.Unlock()
+			return err
+		}
+	}
+	sealed := make(map[uint64]*segment)
+	var target uint64
+	var sealedBytes int64
+	for id, s := range db.segments {
+		if id != db.active.id {
+			sealed[id] = s
+			sealedBytes += s.size
+			target = max(target, id)
+		}
+	}
+	type live struct {
+		key string
+		e   entry
+	}
+	var lives []live
+	for k, e := range db.index {
+		if _, ok := sealed[e.seg]; ok {
+			lives = append(lives, live{k, e})
+		}
+	}
+	db.mu.Unlock()
+
+	if len(sealed) == 0 {
+		return nil
+	}
+	// Copy in file order so the reads are sequential.
+	sort.Slice(lives, func(i, j int) bool {
+		if lives[i].e.seg != lives[j].e.seg {
+			return lives[i].e.seg < lives[j].e.seg
+		}
+		return lives[i].e.off < lives[j].e.off
+	})
+
+	tmp := segmentName(db.dir, target, compactExt+tmpExt)
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	moved := make(map[string]entry, len(lives))
+	var off int64
+	for _, l := range lives {
+		buf := make([]byte, l.e.size)
+		if _, err := sealed[l.e.seg].f.ReadAt(buf, l.e.off); err != nil {
+			out.Close()
+			os.Remove(tmp)
+			return fmt.Errorf("kvstore: compact read: %w", err)
+		}
+		if _, err := out.Write(buf); err != nil {
+			out.Close()
+			os.Remove(tmp)
+			return fmt.Errorf("kvstore: compact write: %w", err)
+		}
+		moved[l.key] = entry{seg: target, off: off, size: l.e.size}
+		off += l.e.size
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, segmentName(db.dir, target, compactExt)); err != nil {
+		return err
+	}
+	if err := syncDir(db.dir); err != nil {
+		return err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		// Committed on disk; the next Open finishes it.
+		return errs.Wrap(errs.ErrClosed, "kvstore: compact")
+	}
+	// Finish on disk before touching the segments or the index: the sealed
+	// files stay open until the compacted one is, so if either step fails
+	// reads keep working and the next Open completes the compaction.
+	if err := compactFinish(db.dir); err != nil {
+		return fmt.Errorf("kvstore: compact: %w", err)
+	}
+	s, err := openSegment(db.dir, target)
+	if err != nil {
+		return fmt.Errorf("kvstore: compact: %w", err)
+	}
+	for id, old := range sealed {
+		old.f.Close()
+		delete(db.segments, id)
+	}
+	db.segments[target] = s
+
+	db.dead -= sealedBytes - s.size
+	for _, l := range lives {
+		// Keys overwritten or deleted while copying keep their new entry;
+		// apply already counted the old record as dead, and the copy
+		// simply takes its place.
+		if cur, ok := db.index[l.key]; ok && cur == l.e {
+			db.index[l.key] = moved[l.key]
+		}
+	}
+	return nil
+}
+
+// compactFinish is finishCompaction; tests replace it to make Compact fail
+// after committing.
+var compactFinish = finishCompaction
+
+// finishCompaction removes abandoned temporary files and completes any
+// committed compaction: segments up to its id are deleted and the
+// compacted file takes the id's place.
+func finishCompaction(dir string) error {
+	tmps, err := listIDs(dir, compactExt+tmpExt)
+	if err != nil {
+		return err
+	}
+	for _, id := range tmps {
+		if err := os.Remove(segmentName(dir, id, compactExt+tmpExt)); err != nil {
+			return err
+		}
+	}
+
+	compacted, err := listIDs(dir, compactExt)
+	if err != nil {
+		return err
+	}
+	for _, cid := range compacted {
+		ids, err := listIDs(dir, segmentExt)
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			if id > cid {
+				break
+			}
+			if err := os.Remove(segmentName(dir, id, segmentExt)); err != nil {
+				return err
+			}
+		}
+		if err := os.Rename(segmentName(dir, cid, compactExt), segmentName(dir, cid, segmentExt)); err != nil {
+			return err
+		}
+	}
+	if len(tmps) > 0 || len(compacted) > 0 {
+		return syncDir(dir)
+	}
+	return nil
+}
@@ -0,0 +1,225 @@
+package kvstore
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestCompact(t *testing.T) {
+	dir := t.TempDir()
+	db := mustOpen(t, dir)
+	want := fill(t, db)
+	before := db.Stats()
+	if err := db.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	after := db.Stats()
+	if after.DeadBytes != 0 || after.Bytes >= before.Bytes || after.Segments != 2 {
+		t.Errorf("Stats before %+v, after %+v", before, after)
+	}
+	if got := contents(t, db); !reflect.DeepEqual(got, want) {
+		t.Errorf("after compaction = %v, want %v", got, want)
+	}
+	db.Close()
+
+	db = mustOpen(t, dir)
+	defer db.Close()
+	if got := contents(t, db); !reflect.DeepEqual(got, want) {
+		t.Errorf("after reopen = %v, want %v", got, want)
+	}
+}
+
+// liveBytes is the size of the records the index points at.
+func liveBytes(db *DB) int64 {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	var n int64
+	for _, e := range db.index {
+		n += e.size
+	}
+	return n
+}
+
+func TestCompactWithConcurrentWrites(t *testing.T) {
+	db := mustOpen(t, t.TempDir())
+	defer db.Close()
+	fill(t, db)
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			k := fmt.Sprintf("k%02d", i%30)
+			if i%7 == 0 {
+				db.Delete(k)
+			} else {
+				db.Put(k, []byte(fmt.Sprint("w", i)))
+			}
+		}
+	}()
+	for i := 0; i < 5; i++ {
+		if err := db.Compact(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(stop)
+	wg.Wait()
+
+	st := db.Stats()
+	if want := st.Bytes - liveBytes(db); st.DeadBytes != want {
+		t.Errorf("DeadBytes = %d, want %d (Bytes %d)", st.DeadBytes, want, st.Bytes)
+	}
+}
+
+func copyDir(t *testing.T, src, dst string) {
+	t.Helper()
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		data, err := os.ReadFile(filepath.Join(src, e.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dst, e.Name()), data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// TestInterruptedCompaction rebuilds the on-disk state a crash would
+// leave after each step of Compact and checks Open recovers all data.
+func TestInterruptedCompaction(t *testing.T) {
+	// Build a store, snapshot it, then compact the original to obtain the
+	// file compaction writes.
+	src := t.TempDir()
+	db := mustOpen(t, src)
+	want := fill(t, db)
+	db.Close()
+	snapshot := t.TempDir()
+	copyDir(t, src, snapshot)
+	sealed, _ := listIDs(snapshot, segmentExt)
+	target := sealed[len(sealed)-1]
+
+	db = mustOpen(t, src)
+	if err := db.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	compacted, err := os.ReadFile(segmentName(src, target, segmentExt))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		setup func(dir string)
+	}{
+		{"while writing the temporary file", func(dir string) {
+			os.WriteFile(segmentName(dir, target, compactExt+tmpExt), compacted[:len(compacted)/2], 0o644)
+		}},
+		{"after commit, before deleting segments", func(dir string) {
+			os.WriteFile(segmentName(dir, target, compactExt), compacted, 0o644)
+		}},
+		{"after deleting some segments", func(dir string) {
+			os.WriteFile(segmentName(dir, target, compactExt), compacted, 0o644)
+			for _, id := range sealed[:len(sealed)/2] {
+				os.Remove(segmentName(dir, id, segmentExt))
+			}
+		}},
+		{"after deleting every segment, before the rename", func(dir string) {
+			os.WriteFile(segmentName(dir, target, compactExt), compacted, 0o644)
+			for _, id := range sealed {
+				os.Remove(segmentName(dir, id, segmentExt))
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			copyDir(t, snapshot, dir)
+			tt.setup(dir)
+
+			db := mustOpen(t, dir)
+			defer db.Close()
+			if got := contents(t, db); !reflect.DeepEqual(got, want) {
+				t.Errorf("recovered %v, want %v", got, want)
+			}
+			for _, ext := range []string{compactExt, compactExt + tmpExt} {
+				if ids, _ := listIDs(dir, ext); len(ids) != 0 {
+					t.Errorf("leftover %s files: %v", ext, ids)
+				}
+			}
+		})
+	}
+}
+
+// TestCompactFinishFails checks that a failure after the compaction is
+// committed leaves the open store readable and a later Compact or Open
+// completes it.
+func TestCompactFinishFails(t *testing.T) {
+	errInjected := errors.New("injected")
+	tests := []struct {
+		name   string
+		finish func(dir string) error
+	}{
+		{"before finishing", func(string) error { return errInjected }},
+		{"after finishing", func(dir string) error {
+			if err := finishCompaction(dir); err != nil {
+				return err
+			}
+			return errInjected
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			db := mustOpen(t, dir)
+			want := fill(t, db)
+
+			compactFinish = tt.finish
+			err := db.Compact()
+			compactFinish = finishCompaction
+			if !errors.Is(err, errInjected) {
+				t.Fatalf("Compact = %v, want injected error", err)
+			}
+			if got := contents(t, db); !reflect.DeepEqual(got, want) {
+				t.Fatalf("after failed compaction = %v, want %v", got, want)
+			}
+			if v, err := db.Get("k05"); err != nil || string(v) != want["k05"] {
+				t.Fatalf("Get = %q, %v", v, err)
+			}
+			if err := db.Put("new", []byte("x")); err != nil {
+				t.Fatal(err)
+			}
+			want["new"] = "x"
+
+			if err := db.Compact(); err != nil {
+				t.Fatal(err)
+			}
+			if got := contents(t, db); !reflect.DeepEqual(got, want) {
+				t.Errorf("after retry = %v, want %v", got, want)
+			}
+			db.Close()
+
+			db = mustOpen(t, dir)
+			defer db.Close()
+			if got := contents(t, db); !reflect.DeepEqual(got, want) {
+				t.Errorf("after reopen = %v, want %v", got, want)
+			}
+		})
+	}
+}
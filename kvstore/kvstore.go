@@ -0,0 +1,339 @@
+// Package kvstore is a small embedded persistent key-value store in the
+// style of Bitcask. Writes are appended to log segments, an in-memory
+// index maps every live key to the position of its latest value, and
+// compaction rewrites sealed segments keeping only live records. On open
+// the index is rebuilt by replaying the segments; a torn write at the end
+// of the newest segment is truncated away.
+package kvstore
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/shameerb/projects-golang/errs"
+	"github.com/shameerb/projects-golang/logging"
+)
+
+// Options configures a DB.
+type Options struct {
+	// MaxSegmentSize is the size at which the active segment is sealed and
+	// a new one started. Zero means 64 MiB.
+	MaxSegmentSize int64
+	// SyncWrites fsyncs after every Put and Delete. Without it, writes
+	// survive a process crash but not necessarily a machine crash.
+	SyncWrites bool
+	// Logger receives recovery warnings, tagged with the kvstore
+	// component. Nil means slog.Default().
+	Logger *slog.Logger
+}
+
+// Stats describes the store's size.
+type Stats struct {
+	Keys      int
+	Segments  int
+	Bytes     int64 // total size of all segments
+	DeadBytes int64 // space held by overwritten or deleted records
+	// TruncatedBytes were dropped from the newest segment by Open because
+	// they held a torn or corrupt record.
+	TruncatedBytes int64
+}
+
+// DB is an open store. It is safe for concurrent use.
+type DB struct {
+	dir  string
+	opts Options
+	lock *os.File
+
+	compactMu sync.Mutex // serializes Compact
+
+	mu        sync.RWMutex
+	segments  map[uint64]*segment
+	active    *segment
+	index     map[string]entry
+	dead      int64
+	truncated int64
+	closed    bool
+}
+
+type entry struct {
+	seg  uint64
+	off  int64
+	size int64
+}
+
+// Open opens or creates the store in dir. The directory is locked for as
+// long as the store is open; opening it again, from this process or
+// another, fails with ErrLocked.
+func Open(dir string, opts Options) (*DB, error) {
+	if opts.MaxSegmentSize <= 0 {
+		opts.MaxSegmentSize = 64 << 20
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+	opts.Logger = logging.ForComponent(opts.Logger, "kvstore")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	lock, err := lockDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	db := &DB{
+		dir:      dir,
+		opts:     opts,
+		lock:     lock,
+		segments: make(map[uint64]*segment),
+		index:    make(map[string]entry),
+	}
+	if err := db.load(); err != nil {
+		db.closeFiles()
+		unlockDir(lock)
+		return nil, err
+	}
+	return db, nil
+}
+
+func (db *DB) load() error {
+	if err := finishCompaction(db.dir); err != nil {
+		return fmt.Errorf("kvstore: recover compaction: %w", err)
+	}
+	ids, err := listIDs(db.dir, segmentExt)
+	if err != nil {
+		return err
+	}
+	for i, id := range ids {
+		s, err := openSegment(db.dir, id)
+		if err != nil {
+			return err
+		}
+		db.segments[id] = s
+		if err := db.replay(s, i == len(ids)-1); err != nil {
+			return err
+		}
+		db.active = s
+	}
+	if db.active == nil {
+		s, err := openSegment(db.dir, 1)
+		if err != nil {
+			return err
+		}
+		db.segments[1] = s
+		db.active = s
+	}
+	return nil
+}
+
+// replay loads s into the index. A damaged tail on the newest segment is
+// normally the remains of an interrupted write and is truncated; how much
+// was dropped is logged and kept in Stats.TruncatedBytes, since a checksum
+// failure can also mean real corruption.
+func (db *DB) replay(s *segment, last bool) error {
+	var off int64
+	for {
+		rec, err := readRecord(s.f, off, s.size)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			if last && (errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, ErrCorrupt)) {
+				dropped := s.size - off
+				db.truncated += dropped
+				db.opts.Logger.Warn("truncating damaged segment tail",
+					"dir", db.dir, "segment", s.id, "offset", off, "dropped_bytes", dropped, logging.Err(err))
+				s.size = off
+				return s.f.Truncate(off)
+			}
+			return fmt.Errorf("kvstore: segment %d at %d: %w", s.id, off, err)
+		}
+		db.apply(rec.op, rec.key, entry{seg: s.id, off: off, size: rec.size})
+		off += rec.size
+	}
+}
+
+// apply updates the index for a record that has been written.
+func (db *DB) apply(op byte, key string, e entry) {
+	if old, ok := db.index[key]; ok {
+		db.dead += old.size
+	}
+	if op == opDelete {
+		delete(db.index, key)
+		db.dead += e.size
+		return
+	}
+	db.index[key] = e
+}
+
+// Get returns the value stored under key, or an error wrapping
+// errs.ErrNotFound.
+func (db *DB) Get(key string) ([]byte, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed {
+		return nil, errs.Wrap(errs.ErrClosed, "kvstore: get")
+	}
+	e, ok := db.index[key]
+	if !ok {
+		return nil, errs.WrapKey(errs.ErrNotFound, "kvstore: get", key)
+	}
+	return db.read(key, e)
+}
+
+func (db *DB) read(key string, e entry) ([]byte, error) {
+	voff := e.off + headerSize + int64(len(key))
+	buf := make([]byte, e.size-headerSize-int64(len(key)))
+	if _, err := db.segments[e.seg].f.ReadAt(buf, voff); err != nil {
+		return nil, fmt.Errorf("kvstore: read %q: %w", key, err)
+	}
+	return buf, nil
+}
+
+// Put stores value under key.
+func (db *DB) Put(key string, value []byte) error {
+	return db.write(opPut, key, value)
+}
+
+// Delete removes key. Deleting a missing key is not an error.
+func (db *DB) Delete(key string) error {
+	db.mu.RLock()
+	_, ok := db.index[key]
+	db.mu.RUnlock()
+	if !ok {
+		return nil
+	}
+	return db.write(opDelete, key, nil)
+}
+
+func (db *DB) write(op byte, key string, value []byte) error {
+	rec := encodeRecord(op, key, value)
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return errs.Wrap(errs.ErrClosed, "kvstore: write")
+	}
+	if db.active.size > 0 && db.active.size+int64(len(rec)) > db.opts.MaxSegmentSize {
+		if err := db.rotate(); err != nil {
+			return err
+		}
+	}
+	off, err := db.active.append(rec)
+	if err != nil {
+		return fmt.Errorf("kvstore: write %q: %w", key, err)
+	}
+	if db.opts.SyncWrites {
+		if err := db.active.f.Sync(); err != nil {
+			return fmt.Errorf("kvstore: sync: %w", err)
+		}
+	}
+	db.apply(op, key, entry{seg: db.active.id, off: off, size: int64(len(rec))})
+	return nil
+}
+
+// rotate seals the active segment and starts a new one.
+func (db *DB) rotate() error {
+	if err := db.active.f.Sync(); err != nil {
+		return fmt.Errorf("kvstore: sync: %w", err)
+	}
+	s, err := openSegment(db.dir, db.active.id+1)
+	if err != nil {
+		return err
+	}
+	db.segments[s.id] = s
+	db.active = s
+	return nil
+}
+
+// Scan calls fn for each key in [start, end) in ascending order, stopping
+// early if fn returns false. An empty end means no upper bound. The store
+// is read-locked for the duration, so fn must not write to it.
+func (db *DB) Scan(start, end string, fn func(key string, value []byte) bool) error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed {
+		return errs.Wrap(errs.ErrClosed, "kvstore: scan")
+	}
+	keys := make([]string, 0, len(db.index))
+	for k := range db.index {
+		if k >= start && (end == "" || k < end) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v, err := db.read(k, db.index[k])
+		if err != nil {
+			return err
+		}
+		if !fn(k, v) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// Len returns the number of live keys.
+func (db *DB) Len() int {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return len(db.index)
+}
+
+// Stats returns the store's size figures.
+func (db *DB) Stats() Stats {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	st := Stats{
+		Keys:           len(db.index),
+		Segments:       len(db.segments),
+		DeadBytes:      db.dead,
+		TruncatedBytes: db.truncated,
+	}
+	for _, s := range db.segments {
+		st.Bytes += s.size
+	}
+	return st
+}
+
+// Sync flushes the active segment to stable storage.
+func (db *DB) Sync() error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed {
+		return errs.Wrap(errs.ErrClosed, "kvstore: sync")
+	}
+	return db.active.f.Sync()
+}
+
+// Close syncs and closes the store.
+func (db *DB) Close() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return errs.Wrap(errs.ErrClosed, "kvstore: close")
+	}
+	db.closed = true
+	err := db.active.f.Sync()
+	if cerr := db.closeFiles(); err == nil {
+		err = cerr
+	}
+	if uerr := unlockDir(db.lock); err == nil {
+		err = uerr
+	}
+	return err
+}
+
+func (db *DB) closeFiles() error {
+	var first error
+	for _, s := range db.segments {
+		if err := s.f.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
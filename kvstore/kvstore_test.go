@@ -0,0 +1,257 @@
+package kvstore
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/shameerb/projects-golang/errs"
+)
+
+func testOptions() Options {
+	return Options{
+		MaxSegmentSize: 256,
+		Logger:         slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+}
+
+func mustOpen(t *testing.T, dir string) *DB {
+	t.Helper()
+	db, err := Open(dir, testOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// contents returns every key and value in the store.
+func contents(t *testing.T, db *DB) map[string]string {
+	t.Helper()
+	out := map[string]string{}
+	err := db.Scan("", "", func(k string, v []byte) bool {
+		out[k] = string(v)
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+// fill writes overlapping keys across several segments and deletes a few.
+func fill(t *testing.T, db *DB) map[string]string {
+	t.Helper()
+	want := map[string]string{}
+	for i := 0; i < 200; i++ {
+		k, v := fmt.Sprintf("k%02d", i%30), fmt.Sprintf("v%d", i)
+		if err := db.Put(k, []byte(v)); err != nil {
+			t.Fatal(err)
+		}
+		want[k] = v
+	}
+	for _, k := range []string{"k03", "k17"} {
+		if err := db.Delete(k); err != nil {
+			t.Fatal(err)
+		}
+		delete(want, k)
+	}
+	return want
+}
+
+func TestPutGetDelete(t *testing.T) {
+	db := mustOpen(t, t.TempDir())
+	defer db.Close()
+
+	if _, err := db.Get("a"); !errors.Is(err, errs.ErrNotFound) {
+		t.Fatalf("Get missing = %v, want ErrNotFound", err)
+	}
+	db.Put("a", []byte("1"))
+	db.Put("a", []byte("2"))
+	if v, err := db.Get("a"); err != nil || string(v) != "2" {
+		t.Fatalf("Get = %q, %v", v, err)
+	}
+	if err := db.Delete("a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Delete("a"); err != nil {
+		t.Fatalf("deleting a missing key = %v", err)
+	}
+	if _, err := db.Get("a"); !errors.Is(err, errs.ErrNotFound) {
+		t.Fatalf("Get deleted = %v", err)
+	}
+}
+
+func TestScanRange(t *testing.T) {
+	db := mustOpen(t, t.TempDir())
+	defer db.Close()
+	for _, k := range []string{"b", "a", "d", "c", "e"} {
+		db.Put(k, []byte(k))
+	}
+	var got []string
+	db.Scan("b", "e", func(k string, _ []byte) bool {
+		got = append(got, k)
+		return k != "c"
+	})
+	if want := []string{"b", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Scan = %v, want %v", got, want)
+	}
+}
+
+func TestReopen(t *testing.T) {
+	dir := t.TempDir()
+	db := mustOpen(t, dir)
+	want := fill(t, db)
+	if st := db.Stats(); st.Segments < 3 {
+		t.Fatalf("expected several segments, got %+v", st)
+	}
+	db.Close()
+
+	db = mustOpen(t, dir)
+	defer db.Close()
+	if got := contents(t, db); !reflect.DeepEqual(got, want) {
+		t.Errorf("after reopen = %v, want %v", got, want)
+	}
+}
+
+func TestClosed(t *testing.T) {
+	db := mustOpen(t, t.TempDir())
+	db.Close()
+	if err := db.Put("a", nil); !errors.Is(err, errs.ErrClosed) {
+		t.Errorf("Put after Close = %v", err)
+	}
+	if _, err := db.Get("a"); !errors.Is(err, errs.ErrClosed) {
+		t.Errorf("Get after Close = %v", err)
+	}
+	if err := db.Close(); !errors.Is(err, errs.ErrClosed) {
+		t.Errorf("second Close = %v", err)
+	}
+}
+
+func TestDirectoryLock(t *testing.T) {
+	dir := t.TempDir()
+	db := mustOpen(t, dir)
+	if _, err := Open(dir, testOptions()); !errors.Is(err, ErrLocked) || !errors.Is(err, errs.ErrConflict) {
+		t.Fatalf("second Open = %v, want ErrLocked", err)
+	}
+	db.Close()
+	db = mustOpen(t, dir)
+	db.Close()
+}
+
+func lastSegment(t *testing.T, dir string) string {
+	t.Helper()
+	ids, err := listIDs(dir, segmentExt)
+	if err != nil || len(ids) == 0 {
+		t.Fatal("no segments", err)
+	}
+	return segmentName(dir, ids[len(ids)-1], segmentExt)
+}
+
+func appendBytes(t *testing.T, path string, b []byte) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.Write(b); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTornTailRecovery(t *testing.T) {
+	dir := t.TempDir()
+	db := mustOpen(t, dir)
+	want := fill(t, db)
+	db.Close()
+
+	torn := encodeRecord(opPut, "torn", []byte("never finished"))
+	appendBytes(t, lastSegment(t, dir), torn[:len(torn)-3])
+
+	db = mustOpen(t, dir)
+	if got := contents(t, db); !reflect.DeepEqual(got, want) {
+		t.Errorf("after recovery = %v, want %v", got, want)
+	}
+	if st := db.Stats(); st.TruncatedBytes != int64(len(torn)-3) {
+		t.Errorf("TruncatedBytes = %d, want %d", st.TruncatedBytes, len(torn)-3)
+	}
+	// The store keeps working and the tail stays gone after another reopen.
+	db.Put("after", []byte("x"))
+	db.Close()
+	db = mustOpen(t, dir)
+	defer db.Close()
+	want["after"] = "x"
+	if got := contents(t, db); !reflect.DeepEqual(got, want) {
+		t.Errorf("after second reopen = %v, want %v", got, want)
+	}
+}
+
+func TestGarbageHeaderDoesNotAllocate(t *testing.T) {
+	dir := t.TempDir()
+	db := mustOpen(t, dir)
+	db.Put("a", []byte("1"))
+	db.Close()
+
+	hdr := make([]byte, headerSize)
+	hdr[4] = opPut
+	binary.BigEndian.PutUint32(hdr[5:], 0xffffffff)
+	binary.BigEndian.PutUint32(hdr[9:], 0xffffffff)
+	appendBytes(t, lastSegment(t, dir), hdr)
+
+	db = mustOpen(t, dir)
+	defer db.Close()
+	if v, err := db.Get("a"); err != nil || string(v) != "1" {
+		t.Errorf("Get = %q, %v", v, err)
+	}
+	if st := db.Stats(); st.TruncatedBytes != headerSize {
+		t.Errorf("TruncatedBytes = %d, want %d", st.TruncatedBytes, headerSize)
+	}
+}
+
+func TestCorruptionInNewestSegmentIsReported(t *testing.T) {
+	dir := t.TempDir()
+	db := mustOpen(t, dir)
+	db.Put("a", []byte("1"))
+	db.Put("b", []byte("2"))
+	db.Close()
+
+	// Flip a value byte in the first record; it and everything after it
+	// are dropped, and the drop is reported.
+	path := lastSegment(t, dir)
+	data, _ := os.ReadFile(path)
+	size := len(data)
+	data[headerSize+1] ^= 0xff
+	os.WriteFile(path, data, 0o644)
+
+	db = mustOpen(t, dir)
+	defer db.Close()
+	if st := db.Stats(); st.Keys != 0 || st.TruncatedBytes != int64(size) {
+		t.Errorf("Stats = %+v, want every byte reported truncated", st)
+	}
+}
+
+func TestCorruptionInSealedSegmentFailsOpen(t *testing.T) {
+	dir := t.TempDir()
+	db := mustOpen(t, dir)
+	fill(t, db)
+	db.Close()
+
+	ids, _ := listIDs(dir, segmentExt)
+	path := segmentName(dir, ids[0], segmentExt)
+	data, _ := os.ReadFile(path)
+	data[headerSize] ^= 0xff
+	os.WriteFile(path, data, 0o644)
+
+	if _, err := Open(dir, testOptions()); !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("Open = %v, want ErrCorrupt", err)
+	}
+	// A failed Open must not leave the directory locked.
+	if _, err := Open(dir, testOptions()); errors.Is(err, ErrLocked) {
+		t.Fatal("failed Open left the directory locked")
+	}
+}
@@ -0,0 +1,27 @@
+//go:build !unix
+
+package kvstore
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// lockDir creates dir/LOCK exclusively. Without flock the file outlives a
+// crash and has to be removed by hand before the store can be reopened.
+func lockDir(dir string) (*os.File, error) {
+	f, err := os.OpenFile(filepath.Join(dir, lockFile), os.O_CREATE|os.O_EXCL|os.O_RDWR, 0o644)
+	if errors.Is(err, os.ErrExist) {
+		return nil, ErrLocked
+	}
+	return f, err
+}
+
+func unlockDir(f *os.File) error {
+	err := f.Close()
+	if rerr := os.Remove(f.Name()); err == nil {
+		err = rerr
+	}
+	return err
+}
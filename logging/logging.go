@@ -0,0 +1,104 @@
+// Package logging wraps log/slog so every package in this repository logs
+// with the same field names and level configuration.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+)
+
+// Field keys shared by every logger.
+const (
+	KeyComponent = "component"
+	KeyRequestID = "request_id"
+	KeyJobID     = "job_id"
+	KeyDuration  = "duration"
+	KeyError     = "error"
+)
+
+// LevelOff disables all output; it is what the "off" and "quiet" levels map to.
+const LevelOff = slog.Level(100)
+
+// Config describes how to build a logger.
+type Config struct {
+	// Level is one of debug, info, warn, error or off/quiet. Empty means info.
+	Level string
+	// Format is "text" (default) or "json".
+	Format string
+	// Output defaults to os.Stderr.
+	Output io.Writer
+}
+
+// New builds a logger from cfg. Request and job ids stored in the context
+// with WithRequestID and WithJobID are added to every record logged with a
+// *Context method.
+func New(cfg Config) (*slog.Logger, error) {
+	level, err := ParseLevel(cfg.Level)
+	if err != nil {
+		return nil, err
+	}
+	out := cfg.Output
+	if out == nil {
+		out = os.Stderr
+	}
+	opts := &slog.HandlerOptions{Level: level}
+
+	var h slog.Handler
+	switch strings.ToLower(cfg.Format) {
+	case "", "text":
+		h = slog.NewTextHandler(out, opts)
+	case "json":
+		h = slog.NewJSONHandler(out, opts)
+	default:
+		return nil, fmt.Errorf("logging: unknown format %q", cfg.Format)
+	}
+	return slog.New(contextHandler{h}), nil
+}
+
+// Discard returns a logger that drops everything, for benchmarks and tests.
+func Discard() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{Level: LevelOff}))
+}
+
+// ParseLevel maps a level name to a slog.Level.
+func ParseLevel(s string) (slog.Level, error) {
+	name := strings.ToLower(strings.TrimSpace(s))
+	switch name {
+	case "":
+		return slog.LevelInfo, nil
+	case "off", "quiet", "none":
+		return LevelOff, nil
+	}
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(name)); err != nil {
+		return 0, fmt.Errorf("logging: unknown level %q", s)
+	}
+	return l, nil
+}
+
+// ForComponent returns l tagged with the component name.
+func ForComponent(l *slog.Logger, name string) *slog.Logger {
+	return l.With(Component(name))
+}
+
+// Component is the attribute naming the package or subsystem logging.
+func Component(name string) slog.Attr { return slog.String(KeyComponent, name) }
+
+// RequestID is the attribute carrying a request id.
+func RequestID(id string) slog.Attr { return slog.String(KeyRequestID, id) }
+
+// JobID is the attribute carrying a job or item id.
+func JobID(id string) slog.Attr { return slog.String(KeyJobID, id) }
+
+// Duration is the attribute carrying an elapsed time.
+func Duration(d time.Duration) slog.Attr { return slog.Duration(KeyDuration, d) }
+
+// Err is the attribute carrying an error.
+func Err(err error) slog.Attr { return slog.Any(KeyError, err) }
+
+// Since is Duration(time.Since(start)).
+func Since(start time.Time) slog.Attr { return Duration(time.Since(start)) }
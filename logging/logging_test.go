@@ -0,0 +1,113 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		in   string
+		want slog.Level
+	}{
+		{"", slog.LevelInfo},
+		{"debug", slog.LevelDebug},
+		{" WARN ", slog.LevelWarn},
+		{"error", slog.LevelError},
+		{"off", LevelOff},
+		{"quiet", LevelOff},
+		{"none", LevelOff},
+	}
+	for _, tt := range tests {
+		got, err := ParseLevel(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseLevel(%q) = %v, %v; want %v", tt.in, got, err, tt.want)
+		}
+	}
+	if _, err := ParseLevel("loud"); err == nil {
+		t.Error("ParseLevel(loud) succeeded")
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	if _, err := New(Config{Level: "loud"}); err == nil {
+		t.Error("New with unknown level succeeded")
+	}
+	if _, err := New(Config{Format: "xml"}); err == nil {
+		t.Error("New with unknown format succeeded")
+	}
+}
+
+func TestLevelFiltering(t *testing.T) {
+	for _, level := range []string{"off", "quiet"} {
+		var buf bytes.Buffer
+		l, _ := New(Config{Level: level, Output: &buf})
+		l.Error("boom")
+		if buf.Len() != 0 {
+			t.Errorf("level %s wrote %q", level, buf.String())
+		}
+	}
+
+	var buf bytes.Buffer
+	l, _ := New(Config{Level: "warn", Output: &buf})
+	l.Info("hidden")
+	l.Warn("shown")
+	if out := buf.String(); strings.Contains(out, "hidden") || !strings.Contains(out, "shown") {
+		t.Errorf("warn level output = %q", out)
+	}
+}
+
+func TestContextIDs(t *testing.T) {
+	var buf bytes.Buffer
+	l, _ := New(Config{Format: "json", Output: &buf})
+	l = ForComponent(l, "queue").With("shard", 2).WithGroup("job")
+
+	ctx := WithJobID(WithRequestID(context.Background(), "r1"), "j1")
+	l.InfoContext(ctx, "done", Since(time.Now()), Err(errors.New("late")))
+
+	var rec map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &rec); err != nil {
+		t.Fatalf("output %q: %v", buf.String(), err)
+	}
+	if rec[KeyComponent] != "queue" || rec["shard"] != float64(2) || rec["msg"] != "done" {
+		t.Errorf("record = %v", rec)
+	}
+	group, _ := rec["job"].(map[string]any)
+	if group[KeyRequestID] != "r1" || group[KeyJobID] != "j1" || group[KeyError] != "late" {
+		t.Errorf("job group = %v", rec["job"])
+	}
+	if _, ok := group[KeyDuration]; !ok {
+		t.Errorf("job group has no %s: %v", KeyDuration, group)
+	}
+
+	buf.Reset()
+	l.Info("plain")
+	if strings.Contains(buf.String(), KeyRequestID) {
+		t.Errorf("record without ids = %q", buf.String())
+	}
+}
+
+func TestTextFormat(t *testing.T) {
+	var buf bytes.Buffer
+	l, _ := New(Config{Output: &buf})
+	l.InfoContext(WithRequestID(context.Background(), "r1"), "hello")
+	if out := buf.String(); !strings.Contains(out, "msg=hello") || !strings.Contains(out, "request_id=r1") {
+		t.Errorf("text output = %q", out)
+	}
+}
+
+func TestLoggerContext(t *testing.T) {
+	if FromContext(context.Background()) != slog.Default() {
+		t.Error("FromContext without a logger is not slog.Default")
+	}
+	l := Discard()
+	if FromContext(NewContext(context.Background(), l)) != l {
+		t.Error("FromContext did not return the stored logger")
+	}
+}
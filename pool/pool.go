@@ -0,0 +1,288 @@
+// Package pool provides a generic pool of reusable resources such as
+// network connections, with limits on idle and active resources, health
+// checks on checkout and background reaping of idle resources.
+package pool
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/shameerb/projects-golang/errs"
+)
+
+// Config describes how a Pool creates, checks and destroys resources.
+type Config[T any] struct {
+	// New creates a resource. Required.
+	New func(ctx context.Context) (T, error)
+	// Close destroys a resource. Optional.
+	Close func(T) error
+	// Ping checks an idle resource before it is handed out; resources
+	// failing it are closed and another is tried. Optional.
+	Ping func(T) error
+	// MaxIdle caps the resources kept for reuse. Zero means 2.
+	MaxIdle int
+	// MaxActive caps resources in use plus idle; Get blocks when it is
+	// reached. Zero means no limit.
+	MaxActive int
+	// IdleTimeout closes resources idle for longer. Zero keeps them.
+	IdleTimeout time.Duration
+	// ReapInterval is how often idle resources are reaped. Zero means
+	// IdleTimeout/2, but at least a millisecond.
+	ReapInterval time.Duration
+}
+
+// Stats is a snapshot of pool usage.
+type Stats struct {
+	Active  int // checked out
+	Idle    int // waiting for reuse
+	Waiters int // callers blocked in Get
+	Created uint64
+	Closed  uint64
+}
+
+// minReapInterval bounds the default ReapInterval for tiny IdleTimeouts.
+const minReapInterval = time.Millisecond
+
+// Pool is a pool of resources of type T. It is safe for concurrent use.
+type Pool[T any] struct {
+	cfg  Config[T]
+	sem  chan struct{} // one token per open resource; nil when unlimited
+	done chan struct{}
+
+	mu      sync.Mutex
+	idle    []idleItem[T] // stack; the most recently used is last
+	closed  bool
+	active  int
+	waiters int
+	created uint64
+	closedN uint64
+}
+
+type idleItem[T any] struct {
+	v     T
+	since time.Time
+}
+
+// New returns a pool using cfg. It panics if cfg.New is nil.
+func New[T any](cfg Config[T]) *Pool[T] {
+	if cfg.New == nil {
+		panic("pool: Config.New is required")
+	}
+	if cfg.MaxIdle <= 0 {
+		cfg.MaxIdle = 2
+	}
+	if cfg.MaxActive > 0 && cfg.MaxIdle > cfg.MaxActive {
+		cfg.MaxIdle = cfg.MaxActive
+	}
+	p := &Pool[T]{cfg: cfg, done: make(chan struct{})}
+	if cfg.MaxActive > 0 {
+		p.sem = make(chan struct{}, cfg.MaxActive)
+	}
+	if cfg.IdleTimeout > 0 {
+		interval := cfg.ReapInterval
+		if interval <= 0 {
+			interval = max(cfg.IdleTimeout/2, minReapInterval)
+		}
+		go p.reaper(interval)
+	}
+	return p
+}
+
+// Get checks out a resource, reusing an idle one when possible. It blocks
+// while MaxActive resources are open, until one is returned, ctx is done
+// or the pool is closed.
+func (p *Pool[T]) Get(ctx context.Context) (T, error) {
+	var zero T
+	if err := p.acquire(ctx); err != nil {
+		return zero, err
+	}
+
+	for {
+		item, ok, err := p.popIdle()
+		if err != nil {
+			p.release()
+			return zero, err
+		}
+		if !ok {
+			break
+		}
+		if p.cfg.IdleTimeout > 0 && time.Since(item.since) > p.cfg.IdleTimeout {
+			p.destroy(item.v)
+			continue
+		}
+		if p.cfg.Ping != nil && p.cfg.Ping(item.v) != nil {
+			p.destroy(item.v)
+			continue
+		}
+		return item.v, nil
+	}
+
+	v, err := p.cfg.New(ctx)
+	if err != nil {
+		p.mu.Lock()
+		p.active--
+		p.mu.Unlock()
+		p.release()
+		return zero, errs.Wrap(err, "pool: new")
+	}
+	p.mu.Lock()
+	p.created++
+	p.mu.Unlock()
+	return v, nil
+}
+
+// Put returns a healthy resource to the pool. Resources beyond MaxIdle,
+// or returned after Close, are closed.
+func (p *Pool[T]) Put(v T) {
+	p.mu.Lock()
+	p.active--
+	keep := !p.closed && len(p.idle) < p.cfg.MaxIdle
+	if keep {
+		p.idle = append(p.idle, idleItem[T]{v: v, since: time.Now()})
+	}
+	p.mu.Unlock()
+
+	if !keep {
+		p.destroy(v)
+	}
+	p.release()
+}
+
+// Discard closes a broken resource instead of returning it.
+func (p *Pool[T]) Discard(v T) {
+	p.mu.Lock()
+	p.active--
+	p.mu.Unlock()
+	p.destroy(v)
+	p.release()
+}
+
+// Close closes idle resources and makes Get fail with errs.ErrClosed.
+// Resources checked out are closed when they are Put back.
+func (p *Pool[T]) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return errs.Wrap(errs.ErrClosed, "pool: close")
+	}
+	p.closed = true
+	idle := p.idle
+	p.idle = nil
+	p.mu.Unlock()
+
+	close(p.done)
+	var first error
+	for _, item := range idle {
+		if err := p.destroy(item.v); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// Stats returns a snapshot of pool usage.
+func (p *Pool[T]) Stats() Stats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return Stats{
+		Active:  p.active,
+		Idle:    len(p.idle),
+		Waiters: p.waiters,
+		Created: p.created,
+		Closed:  p.closedN,
+	}
+}
+
+// acquire reserves capacity for one more checkout.
+func (p *Pool[T]) acquire(ctx context.Context) error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return errs.Wrap(errs.ErrClosed, "pool: get")
+	}
+	p.waiters++
+	p.mu.Unlock()
+
+	var err error
+	if p.sem != nil {
+		select {
+		case p.sem <- struct{}{}:
+		case <-ctx.Done():
+			err = ctx.Err()
+		case <-p.done:
+			err = errs.Wrap(errs.ErrClosed, "pool: get")
+		}
+	}
+
+	p.mu.Lock()
+	p.waiters--
+	if err == nil {
+		p.active++
+	}
+	p.mu.Unlock()
+	return err
+}
+
+func (p *Pool[T]) release() {
+	if p.sem != nil {
+		<-p.sem
+	}
+}
+
+func (p *Pool[T]) popIdle() (idleItem[T], bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		p.active--
+		return idleItem[T]{}, false, errs.Wrap(errs.ErrClosed, "pool: get")
+	}
+	n := len(p.idle)
+	if n == 0 {
+		return idleItem[T]{}, false, nil
+	}
+	item := p.idle[n-1]
+	p.idle[n-1] = idleItem[T]{}
+	p.idle = p.idle[:n-1]
+	return item, true, nil
+}
+
+func (p *Pool[T]) destroy(v T) error {
+	p.mu.Lock()
+	p.closedN++
+	p.mu.Unlock()
+	if p.cfg.Close == nil {
+		return nil
+	}
+	return p.cfg.Close(v)
+}
+
+func (p *Pool[T]) reaper(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-p.done:
+			return
+		case now := <-t.C:
+			p.reap(now)
+		}
+	}
+}
+
+// reap closes resources idle for longer than IdleTimeout. The idle stack
+// is ordered by last use, so stale ones are at the front.
+func (p *Pool[T]) reap(now time.Time) {
+	p.mu.Lock()
+	i := 0
+	for i < len(p.idle) && now.Sub(p.idle[i].since) > p.cfg.IdleTimeout {
+		i++
+	}
+	stale := append([]idleItem[T](nil), p.idle[:i]...)
+	p.idle = append(p.idle[:0], p.idle[i:]...)
+	p.mu.Unlock()
+
+	for _, item := range stale {
+		p.destroy(item.v)
+	}
+}
@@ -0,0 +1,236 @@
+package pool
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/shameerb/projects-golang/errs"
+)
+
+// resources counts how many test resources are open.
+type resources struct {
+	open, peak, made atomic.Int64
+}
+
+func (r *resources) config() Config[int64] {
+	return Config[int64]{
+		New: func(context.Context) (int64, error) {
+			n := r.open.Add(1)
+			for {
+				p := r.peak.Load()
+				if n <= p || r.peak.CompareAndSwap(p, n) {
+					break
+				}
+			}
+			return r.made.Add(1), nil
+		},
+		Close: func(int64) error {
+			r.open.Add(-1)
+			return nil
+		},
+	}
+}
+
+func TestReuse(t *testing.T) {
+	var r resources
+	p := New(r.config())
+	defer p.Close()
+	v, _ := p.Get(context.Background())
+	p.Put(v)
+	w, _ := p.Get(context.Background())
+	if w != v {
+		t.Errorf("Get = %d, want reused %d", w, v)
+	}
+	if s := p.Stats(); s.Created != 1 || s.Active != 1 {
+		t.Errorf("Stats = %+v", s)
+	}
+}
+
+func TestMaxActiveBlocks(t *testing.T) {
+	var r resources
+	cfg := r.config()
+	cfg.MaxActive = 2
+	p := New(cfg)
+	defer p.Close()
+	ctx := context.Background()
+
+	a, _ := p.Get(ctx)
+	p.Get(ctx)
+	short, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if _, err := p.Get(short); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Get past MaxActive = %v, want deadline exceeded", err)
+	}
+
+	got := make(chan int64)
+	go func() {
+		v, _ := p.Get(ctx)
+		got <- v
+	}()
+	for p.Stats().Waiters == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	p.Put(a)
+	select {
+	case v := <-got:
+		if v != a {
+			t.Errorf("waiter got %d, want returned %d", v, a)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("waiter not woken by Put")
+	}
+}
+
+func TestMaxActiveUnderLoad(t *testing.T) {
+	var r resources
+	cfg := r.config()
+	cfg.MaxActive, cfg.MaxIdle = 3, 2
+	p := New(cfg)
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			v, err := p.Get(context.Background())
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			time.Sleep(time.Millisecond)
+			if i%5 == 0 {
+				p.Discard(v)
+			} else {
+				p.Put(v)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if peak := r.peak.Load(); peak > 3 {
+		t.Errorf("peak open resources = %d, want <= 3", peak)
+	}
+	if s := p.Stats(); s.Active != 0 || s.Idle > 2 {
+		t.Errorf("Stats = %+v", s)
+	}
+	p.Close()
+	if n := r.open.Load(); n != 0 {
+		t.Errorf("%d resources left open after Close", n)
+	}
+}
+
+func TestPingDropsBroken(t *testing.T) {
+	var r resources
+	cfg := r.config()
+	cfg.Ping = func(v int64) error {
+		if v == 1 {
+			return errors.New("broken")
+		}
+		return nil
+	}
+	p := New(cfg)
+	defer p.Close()
+	v, _ := p.Get(context.Background())
+	p.Put(v)
+	if w, _ := p.Get(context.Background()); w == 1 {
+		t.Error("resource failing Ping was handed out")
+	}
+	if n := r.open.Load(); n != 1 {
+		t.Errorf("open = %d, want broken resource closed", n)
+	}
+}
+
+func TestIdleReaping(t *testing.T) {
+	var r resources
+	cfg := r.config()
+	cfg.MaxIdle = 4
+	cfg.IdleTimeout = 20 * time.Millisecond
+	cfg.ReapInterval = 5 * time.Millisecond
+	p := New(cfg)
+	defer p.Close()
+
+	var vs []int64
+	for i := 0; i < 3; i++ {
+		v, _ := p.Get(context.Background())
+		vs = append(vs, v)
+	}
+	for _, v := range vs {
+		p.Put(v)
+	}
+	deadline := time.Now().Add(time.Second)
+	for p.Stats().Idle > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("idle resources not reaped: %+v", p.Stats())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n := r.open.Load(); n != 0 {
+		t.Errorf("open = %d after reaping, want 0", n)
+	}
+}
+
+func TestTinyIdleTimeout(t *testing.T) {
+	var r resources
+	cfg := r.config()
+	cfg.IdleTimeout = time.Nanosecond
+	p := New(cfg)
+	defer p.Close()
+	v, _ := p.Get(context.Background())
+	p.Put(v)
+	if w, _ := p.Get(context.Background()); w == v {
+		t.Errorf("Get reused %d past its idle timeout", v)
+	}
+}
+
+func TestClose(t *testing.T) {
+	var r resources
+	cfg := r.config()
+	cfg.MaxActive = 1
+	p := New(cfg)
+	ctx := context.Background()
+
+	held, _ := p.Get(ctx)
+	blocked := make(chan error)
+	go func() {
+		_, err := p.Get(ctx)
+		blocked <- err
+	}()
+	for p.Stats().Waiters == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-blocked; !errors.Is(err, errs.ErrClosed) {
+		t.Errorf("blocked Get = %v, want ErrClosed", err)
+	}
+	if _, err := p.Get(ctx); !errors.Is(err, errs.ErrClosed) {
+		t.Errorf("Get after Close = %v, want ErrClosed", err)
+	}
+	p.Put(held)
+	if n := r.open.Load(); n != 0 {
+		t.Errorf("resource returned after Close left open")
+	}
+	if err := p.Close(); !errors.Is(err, errs.ErrClosed) {
+		t.Errorf("second Close = %v, want ErrClosed", err)
+	}
+}
+
+func TestNewError(t *testing.T) {
+	boom := errors.New("dial failed")
+	p := New(Config[int]{
+		New:       func(context.Context) (int, error) { return 0, boom },
+		MaxActive: 1,
+	})
+	defer p.Close()
+	for i := 0; i < 3; i++ {
+		if _, err := p.Get(context.Background()); !errors.Is(err, boom) {
+			t.Fatalf("Get = %v, want wrapped dial error", err)
+		}
+	}
+	if s := p.Stats(); s.Active != 0 {
+		t.Errorf("failed creations leaked capacity: %+v", s)
+	}
+}
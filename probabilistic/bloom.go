@@ -0,0 +1,157 @@
+package probabilistic
+
+import (
+	"encoding/binary"
+	"math"
+	"math/bits"
+)
+
+// BloomFilter answers "possibly in the set" or "definitely not in the set".
+type BloomFilter struct {
+	m    uint64 // bits
+	k    uint64 // hash functions
+	set  []uint64
+	locs []uint64
+}
+
+// NewBloomFilter returns a filter with m bits and k hash functions.
+func NewBloomFilter(m, k uint64) *BloomFilter {
+	if m == 0 || k == 0 {
+		panic("probabilistic: m and k must be positive")
+	}
+	return &BloomFilter{m: m, k: k, set: make([]uint64, (m+63)/64)}
+}
+
+// NewBloomFilterWithEstimates sizes a filter for n items at the given
+// false-positive rate.
+func NewBloomFilterWithEstimates(n uint64, fpRate float64) *BloomFilter {
+	m, k := EstimateParameters(n, fpRate)
+	return NewBloomFilter(m, k)
+}
+
+// EstimateParameters returns the bit count m and hash count k that hold n
+// items at the given false-positive rate.
+func EstimateParameters(n uint64, fpRate float64) (m, k uint64) {
+	if n == 0 {
+		n = 1
+	}
+	if fpRate <= 0 || fpRate >= 1 {
+		panic("probabilistic: false-positive rate must be in (0, 1)")
+	}
+	mf := math.Ceil(-float64(n) * math.Log(fpRate) / (math.Ln2 * math.Ln2))
+	kf := math.Round(mf / float64(n) * math.Ln2)
+	return uint64(mf), uint64(math.Max(1, kf))
+}
+
+// Cap returns the number of bits.
+func (f *BloomFilter) Cap() uint64 { return f.m }
+
+// K returns the number of hash functions.
+func (f *BloomFilter) K() uint64 { return f.k }
+
+// Add inserts data.
+func (f *BloomFilter) Add(data []byte) {
+	f.locs = locations(data, f.k, f.m, f.locs)
+	for _, l := range f.locs {
+		f.set[l/64] |= 1 << (l % 64)
+	}
+}
+
+// AddString inserts s.
+func (f *BloomFilter) AddString(s string) { f.Add([]byte(s)) }
+
+// Test reports whether data may have been added.
+func (f *BloomFilter) Test(data []byte) bool {
+	f.locs = locations(data, f.k, f.m, f.locs)
+	for _, l := range f.locs {
+		if f.set[l/64]&(1<<(l%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// TestString reports whether s may have been added.
+func (f *BloomFilter) TestString(s string) bool { return f.Test([]byte(s)) }
+
+// TestAndAdd reports whether data may have been added, then adds it.
+func (f *BloomFilter) TestAndAdd(data []byte) bool {
+	f.locs = locations(data, f.k, f.m, f.locs)
+	present := true
+	for _, l := range f.locs {
+		w, b := l/64, uint64(1)<<(l%64)
+		if f.set[w]&b == 0 {
+			present = false
+			f.set[w] |= b
+		}
+	}
+	return present
+}
+
+// Merge adds every item of other to f. Both must share m and k.
+func (f *BloomFilter) Merge(other *BloomFilter) error {
+	if f.m != other.m || f.k != other.k {
+		return ErrIncompatible
+	}
+	for i, w := range other.set {
+		f.set[i] |= w
+	}
+	return nil
+}
+
+// Reset empties the filter.
+func (f *BloomFilter) Reset() {
+	clear(f.set)
+}
+
+// FillRatio returns the fraction of bits set.
+func (f *BloomFilter) FillRatio() float64 {
+	var n int
+	for _, w := range f.set {
+		n += bits.OnesCount64(w)
+	}
+	return float64(n) / float64(f.m)
+}
+
+// EstimatedFalsePositiveRate returns the current false-positive
+// probability, derived from the fill ratio.
+func (f *BloomFilter) EstimatedFalsePositiveRate() float64 {
+	return math.Pow(f.FillRatio(), float64(f.k))
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+func (f *BloomFilter) MarshalBinary() ([]byte, error) {
+	buf := make([]byte, 0, 18+8*len(f.set))
+	buf = append(buf, tagBloom, formatVersion)
+	buf = binary.BigEndian.AppendUint64(buf, f.m)
+	buf = binary.BigEndian.AppendUint64(buf, f.k)
+	for _, w := range f.set {
+		buf = binary.BigEndian.AppendUint64(buf, w)
+	}
+	return buf, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+func (f *BloomFilter) UnmarshalBinary(data []byte) error {
+	if len(data) < 18 || data[0] != tagBloom || data[1] != formatVersion {
+		return ErrInvalidData
+	}
+	m := binary.BigEndian.Uint64(data[2:])
+	k := binary.BigEndian.Uint64(data[10:])
+	data = data[18:]
+	// Size everything from the body and check m against it, so a crafted
+	// m cannot wrap around or outrun the bit set.
+	if len(data) == 0 || len(data)%8 != 0 || k == 0 || k > maxK {
+		return ErrInvalidData
+	}
+	words := uint64(len(data) / 8)
+	if m <= (words-1)*64 || m > words*64 {
+		return ErrInvalidData
+	}
+	set := make([]uint64, words)
+	for i := range set {
+		set[i] = binary.BigEndian.Uint64(data[8*i:])
+	}
+	*f = BloomFilter{m: m, k: k, set: set}
+	return nil
+}
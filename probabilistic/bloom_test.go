@@ -0,0 +1,143 @@
+package probabilistic
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestBloomFalsePositiveRate(t *testing.T) {
+	const n, target = 10_000, 0.01
+	f := NewBloomFilterWithEstimates(n, target)
+	for i := 0; i < n; i++ {
+		f.AddString(fmt.Sprint("in-", i))
+	}
+	for i := 0; i < n; i++ {
+		if !f.TestString(fmt.Sprint("in-", i)) {
+			t.Fatalf("false negative for item %d", i)
+		}
+	}
+	fp := 0
+	const probes = 100_000
+	for i := 0; i < probes; i++ {
+		if f.TestString(fmt.Sprint("out-", i)) {
+			fp++
+		}
+	}
+	if rate := float64(fp) / probes; rate > 1.5*target {
+		t.Errorf("false-positive rate %.4f, want <= %.4f", rate, 1.5*target)
+	}
+	if est := f.EstimatedFalsePositiveRate(); est > 1.5*target {
+		t.Errorf("estimated false-positive rate %.4f, want <= %.4f", est, 1.5*target)
+	}
+}
+
+func TestBloomTestAndAdd(t *testing.T) {
+	f := NewBloomFilter(1024, 3)
+	if f.TestAndAdd([]byte("a")) {
+		t.Error("TestAndAdd reported a new item as present")
+	}
+	if !f.TestAndAdd([]byte("a")) {
+		t.Error("TestAndAdd missed an added item")
+	}
+}
+
+func TestBloomMerge(t *testing.T) {
+	a, b := NewBloomFilter(1024, 3), NewBloomFilter(1024, 3)
+	a.AddString("a")
+	b.AddString("b")
+	if err := a.Merge(b); err != nil {
+		t.Fatal(err)
+	}
+	if !a.TestString("a") || !a.TestString("b") {
+		t.Error("merged filter is missing an item")
+	}
+	if err := a.Merge(NewBloomFilter(2048, 3)); !errors.Is(err, ErrIncompatible) {
+		t.Errorf("Merge with different m = %v, want ErrIncompatible", err)
+	}
+}
+
+func TestBloomMarshalRoundTrip(t *testing.T) {
+	for _, m := range []uint64{1, 63, 64, 65, 1000} {
+		f := NewBloomFilter(m, 4)
+		f.AddString("x")
+		data, err := f.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var g BloomFilter
+		if err := g.UnmarshalBinary(data); err != nil {
+			t.Fatalf("m=%d: %v", m, err)
+		}
+		if g.Cap() != m || g.K() != 4 || !g.TestString("x") {
+			t.Errorf("m=%d: round trip lost state", m)
+		}
+	}
+}
+
+func TestBloomUnmarshalRejectsBadInput(t *testing.T) {
+	header := func(m, k uint64, words int) []byte {
+		buf := []byte{tagBloom, formatVersion}
+		buf = binary.BigEndian.AppendUint64(buf, m)
+		buf = binary.BigEndian.AppendUint64(buf, k)
+		return append(buf, make([]byte, 8*words)...)
+	}
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"short", []byte{tagBloom}},
+		{"wrong tag", append([]byte{tagHyperLogLog}, header(64, 1, 1)[1:]...)},
+		{"m overflows word count", header(1<<64-1, 3, 0)},
+		{"m too large for body", header(129, 3, 2)},
+		{"m too small for body", header(64, 3, 2)},
+		{"zero k", header(64, 0, 1)},
+		{"huge k", header(64, 1<<40, 1)},
+		{"ragged body", append(header(64, 3, 1), 0)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var f BloomFilter
+			if err := f.UnmarshalBinary(tt.data); !errors.Is(err, ErrInvalidData) {
+				t.Errorf("UnmarshalBinary = %v, want ErrInvalidData", err)
+			}
+		})
+	}
+}
+
+func TestCountingBloomRemove(t *testing.T) {
+	f := NewCountingBloomFilterWithEstimates(1000, 0.01)
+	f.Add([]byte("a"))
+	f.Add([]byte("a"))
+	f.Add([]byte("b"))
+	if !f.Remove([]byte("a")) || !f.Test([]byte("a")) {
+		t.Fatal("item added twice should survive one Remove")
+	}
+	f.Remove([]byte("a"))
+	if f.Test([]byte("a")) {
+		t.Error("item still present after removing every copy")
+	}
+	if !f.Test([]byte("b")) {
+		t.Error("removing a evicted b")
+	}
+	if f.Remove([]byte("never")) {
+		t.Error("Remove of an absent item reported success")
+	}
+}
+
+func TestCountingBloomMarshalRoundTrip(t *testing.T) {
+	f := NewCountingBloomFilter(100, 3)
+	f.Add([]byte("x"))
+	data, _ := f.MarshalBinary()
+	var g CountingBloomFilter
+	if err := g.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if !g.Test([]byte("x")) || !g.Remove([]byte("x")) || g.Test([]byte("x")) {
+		t.Error("round trip lost counters")
+	}
+	if err := g.UnmarshalBinary(data[:len(data)-1]); !errors.Is(err, ErrInvalidData) {
+		t.Errorf("truncated input = %v, want ErrInvalidData", err)
+	}
+}
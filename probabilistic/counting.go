@@ -0,0 +1,95 @@
+package probabilistic
+
+import "encoding/binary"
+
+// CountingBloomFilter is a Bloom filter with 8-bit saturating counters in
+// place of bits, so items can be removed. A counter that reaches 255
+// stays there, trading a slightly higher false-positive rate for never
+// producing false negatives.
+type CountingBloomFilter struct {
+	m        uint64
+	k        uint64
+	counters []uint8
+	locs     []uint64
+}
+
+// NewCountingBloomFilter returns a filter with m counters and k hash
+// functions.
+func NewCountingBloomFilter(m, k uint64) *CountingBloomFilter {
+	if m == 0 || k == 0 {
+		panic("probabilistic: m and k must be positive")
+	}
+	return &CountingBloomFilter{m: m, k: k, counters: make([]uint8, m)}
+}
+
+// NewCountingBloomFilterWithEstimates sizes a filter for n items at the
+// given false-positive rate.
+func NewCountingBloomFilterWithEstimates(n uint64, fpRate float64) *CountingBloomFilter {
+	m, k := EstimateParameters(n, fpRate)
+	return NewCountingBloomFilter(m, k)
+}
+
+// Add inserts data.
+func (f *CountingBloomFilter) Add(data []byte) {
+	f.locs = locations(data, f.k, f.m, f.locs)
+	for _, l := range f.locs {
+		if f.counters[l] < 255 {
+			f.counters[l]++
+		}
+	}
+}
+
+// Remove deletes one occurrence of data. It reports false, changing
+// nothing, if data is definitely not present. Removing an item that was
+// never added may evict others that share its counters.
+func (f *CountingBloomFilter) Remove(data []byte) bool {
+	if !f.Test(data) {
+		return false
+	}
+	for _, l := range f.locs {
+		if f.counters[l] < 255 {
+			f.counters[l]--
+		}
+	}
+	return true
+}
+
+// Test reports whether data may be present.
+func (f *CountingBloomFilter) Test(data []byte) bool {
+	f.locs = locations(data, f.k, f.m, f.locs)
+	for _, l := range f.locs {
+		if f.counters[l] == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Reset empties the filter.
+func (f *CountingBloomFilter) Reset() {
+	clear(f.counters)
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+func (f *CountingBloomFilter) MarshalBinary() ([]byte, error) {
+	buf := make([]byte, 0, 18+len(f.counters))
+	buf = append(buf, tagCountingBloom, formatVersion)
+	buf = binary.BigEndian.AppendUint64(buf, f.m)
+	buf = binary.BigEndian.AppendUint64(buf, f.k)
+	return append(buf, f.counters...), nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+func (f *CountingBloomFilter) UnmarshalBinary(data []byte) error {
+	if len(data) < 18 || data[0] != tagCountingBloom || data[1] != formatVersion {
+		return ErrInvalidData
+	}
+	m := binary.BigEndian.Uint64(data[2:])
+	k := binary.BigEndian.Uint64(data[10:])
+	data = data[18:]
+	if m == 0 || k == 0 || k > maxK || uint64(len(data)) != m {
+		return ErrInvalidData
+	}
+	*f = CountingBloomFilter{m: m, k: k, counters: append([]uint8(nil), data...)}
+	return nil
+}
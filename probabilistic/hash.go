@@ -0,0 +1,60 @@
+// Package probabilistic provides space-efficient approximate set and
+// cardinality structures: a Bloom filter, a counting Bloom filter that
+// supports removal, and HyperLogLog. All of them serialize with
+// MarshalBinary/UnmarshalBinary. None of them are safe for concurrent use;
+// callers sharing one across goroutines must lock around it.
+package probabilistic
+
+import (
+	"errors"
+	"hash/fnv"
+)
+
+var (
+	// ErrIncompatible is returned when merging structures built with
+	// different parameters.
+	ErrIncompatible = errors.New("probabilistic: incompatible parameters")
+	// ErrInvalidData is returned when unmarshaling malformed input.
+	ErrInvalidData = errors.New("probabilistic: invalid encoded data")
+)
+
+// Leading bytes of every encoding, identifying the structure and format
+// version.
+const (
+	tagBloom         = 'B'
+	tagCountingBloom = 'C'
+	tagHyperLogLog   = 'H'
+	formatVersion    = 1
+
+	// maxK bounds the hash count accepted when decoding; sensible
+	// parameters never come close.
+	maxK = 256
+)
+
+func hash64(data []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(data)
+	return mix64(h.Sum64())
+}
+
+// mix64 is the murmur3 finalizer; it spreads FNV's output over all bits.
+func mix64(x uint64) uint64 {
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+// locations returns the k positions for data among m slots using double
+// hashing (Kirsch and Mitzenmacher), so one hash pass serves every probe.
+func locations(data []byte, k, m uint64, out []uint64) []uint64 {
+	h1 := hash64(data)
+	h2 := mix64(h1^0x9e3779b97f4a7c15) | 1
+	out = out[:0]
+	for i := uint64(0); i < k; i++ {
+		out = append(out, (h1+i*h2)%m)
+	}
+	return out
+}
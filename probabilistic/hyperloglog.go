@@ -0,0 +1,106 @@
+package probabilistic
+
+import (
+	"math"
+	"math/bits"
+)
+
+// HyperLogLog estimates the number of distinct items added using 2^p
+// one-byte registers, with a standard error of about 1.04/sqrt(2^p).
+type HyperLogLog struct {
+	p         uint8
+	registers []uint8
+}
+
+// NewHyperLogLog returns a sketch with precision p, between 4 and 18.
+// p = 14 (16 KiB, ~0.8% error) is a good default.
+func NewHyperLogLog(p uint8) *HyperLogLog {
+	if p < 4 || p > 18 {
+		panic("probabilistic: precision must be between 4 and 18")
+	}
+	return &HyperLogLog{p: p, registers: make([]uint8, 1<<p)}
+}
+
+// Add records data.
+func (h *HyperLogLog) Add(data []byte) {
+	x := hash64(data)
+	idx := x >> (64 - h.p)
+	// The sentinel bit caps the rank when the remaining bits are all zero.
+	w := x<<h.p | 1<<(h.p-1)
+	rank := uint8(bits.LeadingZeros64(w)) + 1
+	if rank > h.registers[idx] {
+		h.registers[idx] = rank
+	}
+}
+
+// AddString records s.
+func (h *HyperLogLog) AddString(s string) { h.Add([]byte(s)) }
+
+// Count returns the estimated number of distinct items added.
+func (h *HyperLogLog) Count() uint64 {
+	m := float64(len(h.registers))
+	var sum float64
+	zeros := 0
+	for _, r := range h.registers {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+	est := alpha(len(h.registers)) * m * m / sum
+	if est <= 2.5*m && zeros > 0 {
+		// Small-range correction: linear counting is more accurate here.
+		est = m * math.Log(m/float64(zeros))
+	}
+	return uint64(est + 0.5)
+}
+
+// Merge folds other into h, so h counts the union. Both must share p.
+func (h *HyperLogLog) Merge(other *HyperLogLog) error {
+	if h.p != other.p {
+		return ErrIncompatible
+	}
+	for i, r := range other.registers {
+		if r > h.registers[i] {
+			h.registers[i] = r
+		}
+	}
+	return nil
+}
+
+// Reset empties the sketch.
+func (h *HyperLogLog) Reset() {
+	clear(h.registers)
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+func (h *HyperLogLog) MarshalBinary() ([]byte, error) {
+	buf := make([]byte, 0, 3+len(h.registers))
+	buf = append(buf, tagHyperLogLog, formatVersion, h.p)
+	return append(buf, h.registers...), nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+func (h *HyperLogLog) UnmarshalBinary(data []byte) error {
+	if len(data) < 3 || data[0] != tagHyperLogLog || data[1] != formatVersion {
+		return ErrInvalidData
+	}
+	p := data[2]
+	if p < 4 || p > 18 || len(data)-3 != 1<<p {
+		return ErrInvalidData
+	}
+	*h = HyperLogLog{p: p, registers: append([]uint8(nil), data[3:]...)}
+	return nil
+}
+
+func alpha(m int) float64 {
+	switch m {
+	case 16:
+		return 0.673
+	case 32:
+		return 0.697
+	case 64:
+		return 0.709
+	}
+	return 0.7213 / (1 + 1.079/float64(m))
+}
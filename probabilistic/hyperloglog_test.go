@@ -0,0 +1,57 @@
+package probabilistic
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestHyperLogLogError(t *testing.T) {
+	for _, n := range []int{10, 1_000, 100_000, 1_000_000} {
+		h := NewHyperLogLog(14)
+		for i := 0; i < n; i++ {
+			h.AddString(fmt.Sprint("item-", i))
+			h.AddString(fmt.Sprint("item-", i)) // duplicates must not count
+		}
+		// Standard error at p=14 is ~0.8%; allow four of them.
+		if rel := math.Abs(float64(h.Count())-float64(n)) / float64(n); rel > 0.035 {
+			t.Errorf("n=%d: Count = %d, relative error %.3f", n, h.Count(), rel)
+		}
+	}
+}
+
+func TestHyperLogLogMerge(t *testing.T) {
+	a, b := NewHyperLogLog(12), NewHyperLogLog(12)
+	for i := 0; i < 50_000; i++ {
+		a.AddString(fmt.Sprint(i))
+		b.AddString(fmt.Sprint(i + 25_000))
+	}
+	if err := a.Merge(b); err != nil {
+		t.Fatal(err)
+	}
+	if rel := math.Abs(float64(a.Count())-75_000) / 75_000; rel > 0.07 {
+		t.Errorf("union Count = %d, want about 75000", a.Count())
+	}
+	if err := a.Merge(NewHyperLogLog(10)); !errors.Is(err, ErrIncompatible) {
+		t.Errorf("Merge with different p = %v, want ErrIncompatible", err)
+	}
+}
+
+func TestHyperLogLogMarshalRoundTrip(t *testing.T) {
+	h := NewHyperLogLog(10)
+	for i := 0; i < 5000; i++ {
+		h.AddString(fmt.Sprint(i))
+	}
+	data, _ := h.MarshalBinary()
+	var g HyperLogLog
+	if err := g.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if g.Count() != h.Count() {
+		t.Errorf("Count after round trip = %d, want %d", g.Count(), h.Count())
+	}
+	if err := g.UnmarshalBinary(data[:len(data)-1]); !errors.Is(err, ErrInvalidData) {
+		t.Errorf("truncated input = %v, want ErrInvalidData", err)
+	}
+}
@@ -0,0 +1,80 @@
+package ratelimiter
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Keyed holds one limiter per key (client IP, user id, API key, ...),
+// created on first use and dropped after sitting idle for the idle
+// timeout.
+type Keyed struct {
+	newLimiter func() Limiter
+	idle       time.Duration
+
+	mu        sync.Mutex
+	limiters  map[string]*keyedEntry
+	lastSweep time.Time
+}
+
+type keyedEntry struct {
+	lim      Limiter
+	lastSeen time.Time
+}
+
+// NewKeyed returns a Keyed map creating limiters with newLimiter. Limiters
+// unused for idle are forgotten; zero keeps them forever.
+func NewKeyed(newLimiter func() Limiter, idle time.Duration) *Keyed {
+	return &Keyed{
+		newLimiter: newLimiter,
+		idle:       idle,
+		limiters:   make(map[string]*keyedEntry),
+	}
+}
+
+// Get returns the limiter for key, creating it if needed.
+func (k *Keyed) Get(key string) Limiter {
+	now := time.Now()
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.sweep(now)
+	e, ok := k.limiters[key]
+	if !ok {
+		e = &keyedEntry{lim: k.newLimiter()}
+		k.limiters[key] = e
+	}
+	e.lastSeen = now
+	return e.lim
+}
+
+// Allow is Get(key).Allow().
+func (k *Keyed) Allow(key string) bool { return k.Get(key).Allow() }
+
+// Wait is Get(key).Wait(ctx).
+func (k *Keyed) Wait(ctx context.Context, key string) error { return k.Get(key).Wait(ctx) }
+
+// Reserve is Get(key).Reserve().
+func (k *Keyed) Reserve(key string) Reservation { return k.Get(key).Reserve() }
+
+// Len returns the number of tracked keys.
+func (k *Keyed) Len() int {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return len(k.limiters)
+}
+
+// sweep drops idle limiters, at most once per idle period so Get stays
+// cheap.
+func (k *Keyed) sweep(now time.Time) {
+	if k.idle <= 0 || now.Sub(k.lastSweep) < k.idle {
+		return
+	}
+	k.lastSweep = now
+	for key, e := range k.limiters {
+		if now.Sub(e.lastSeen) >= k.idle {
+			delete(k.limiters, key)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package ratelimiter
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// LeakyBucket lets events out at a constant rate, queueing up to capacity
+// events that arrive faster. Unlike TokenBucket it never lets a burst
+// through: admitted events are always at least 1/rate apart.
+type LeakyBucket struct {
+	mu       sync.Mutex
+	interval time.Duration
+	capacity int
+	next     time.Time // earliest time the next event may leak out
+}
+
+// NewLeakyBucket returns a bucket leaking rate events per second and
+// holding at most capacity waiting events.
+func NewLeakyBucket(rate float64, capacity int) *LeakyBucket {
+	if rate <= 0 {
+		panic("ratelimiter: rate must be positive")
+	}
+	if capacity < 1 {
+		panic("ratelimiter: capacity must be at least 1")
+	}
+	return &LeakyBucket{
+		interval: time.Duration(float64(time.Second) / rate),
+		capacity: capacity,
+	}
+}
+
+func (b *LeakyBucket) Allow() bool                    { return allow(b) }
+func (b *LeakyBucket) Wait(ctx context.Context) error { return wait(ctx, b) }
+func (b *LeakyBucket) Reserve() Reservation           { return reserve(b) }
+
+// Queued returns the number of events admitted but not yet leaked.
+func (b *LeakyBucket) Queued() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.queued(time.Now())
+}
+
+func (b *LeakyBucket) queued(now time.Time) int {
+	if !b.next.After(now) {
+		return 0
+	}
+	return int((b.next.Sub(now) + b.interval - 1) / b.interval)
+}
+
+func (b *LeakyBucket) reserve(now time.Time, maxWait time.Duration) (time.Time, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.queued(now) >= b.capacity {
+		// Space opens once the queue drains to capacity-1 events.
+		return b.next.Add(-time.Duration(b.capacity-1) * b.interval), false
+	}
+	at := now
+	if b.next.After(now) {
+		at = b.next
+	}
+	if maxWait >= 0 && at.Sub(now) > maxWait {
+		return at, false
+	}
+	b.next = at.Add(b.interval)
+	return at, true
+}
+
+// cancel frees the slot at at if it was the last one handed out, rolling
+// the schedule back. An earlier slot stays used: later events keep their
+// times, and giving its interval to the next reservation would let two
+// events out less than 1/rate apart.
+func (b *LeakyBucket) cancel(at, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !at.Add(b.interval).Equal(b.next) {
+		return
+	}
+	if at.Before(now) {
+		at = now
+	}
+	b.next = at
+}
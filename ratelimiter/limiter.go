@@ -0,0 +1,128 @@
+// Package ratelimiter implements token bucket, leaky bucket and
+// sliding-window-log rate limiting behind a common Limiter interface,
+// along with per-key limiter maps and an HTTP middleware.
+package ratelimiter
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrLimited is returned by Wait when the request cannot be admitted
+// before the context deadline, or ever (a full leaky bucket).
+var ErrLimited = errors.New("ratelimiter: rate limit exceeded")
+
+// Limiter admits or delays events.
+type Limiter interface {
+	// Allow reports whether an event may happen now, consuming capacity if so.
+	Allow() bool
+	// Wait blocks until an event may happen or ctx is done.
+	Wait(ctx context.Context) error
+	// Reserve claims capacity for one event and reports how long the
+	// caller must wait before acting on it.
+	Reserve() Reservation
+}
+
+// Reservation is the outcome of Limiter.Reserve.
+type Reservation struct {
+	ok     bool
+	delay  time.Duration
+	cancel func()
+}
+
+// OK reports whether capacity was reserved. A leaky bucket that is full
+// refuses reservations.
+func (r Reservation) OK() bool { return r.ok }
+
+// Delay is how long to wait before acting on the reservation. When the
+// reservation is not OK it estimates how long until capacity frees up,
+// or is zero if that is unknown.
+func (r Reservation) Delay() time.Duration { return r.delay }
+
+// Cancel gives the reserved capacity back to the limiter, as far as it
+// can without disturbing later reservations. It does nothing once the
+// reservation's time has passed or if it was not OK; calling it more than
+// once is safe.
+func (r Reservation) Cancel() {
+	if r.cancel != nil {
+		r.cancel()
+	}
+}
+
+// reserver is implemented by every algorithm. reserve claims capacity at
+// now if it can be granted within maxWait (negative means no limit) and
+// returns the time the event may happen. When it refuses, at is the
+// earliest time a claim could succeed, or zero if unknown. cancel returns
+// the capacity claimed for at.
+type reserver interface {
+	reserve(now time.Time, maxWait time.Duration) (at time.Time, ok bool)
+	cancel(at, now time.Time)
+}
+
+const noLimit = time.Duration(-1)
+
+func allow(r reserver) bool {
+	_, ok := r.reserve(time.Now(), 0)
+	return ok
+}
+
+func reserve(r reserver) Reservation {
+	return newReservation(r, time.Now(), noLimit)
+}
+
+func newReservation(r reserver, now time.Time, maxWait time.Duration) Reservation {
+	at, ok := r.reserve(now, maxWait)
+	if !ok {
+		var d time.Duration
+		if !at.IsZero() && at.After(now) {
+			d = at.Sub(now)
+		}
+		return Reservation{delay: d}
+	}
+	var once sync.Once
+	return Reservation{
+		ok:    true,
+		delay: at.Sub(now),
+		cancel: func() {
+			once.Do(func() {
+				if now := time.Now(); now.Before(at) {
+					r.cancel(at, now)
+				}
+			})
+		},
+	}
+}
+
+func wait(ctx context.Context, r reserver) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	now := time.Now()
+	maxWait := noLimit
+	if dl, ok := ctx.Deadline(); ok {
+		maxWait = dl.Sub(now)
+		if maxWait < 0 {
+			maxWait = 0
+		}
+	}
+	res := newReservation(r, now, maxWait)
+	if !res.OK() {
+		return ErrLimited
+	}
+	if res.Delay() <= 0 {
+		return nil
+	}
+	t := time.NewTimer(res.Delay())
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		// Give the slot back so a cancelled waiter does not throttle
+		// everyone behind it.
+		res.Cancel()
+		return ctx.Err()
+	}
+}
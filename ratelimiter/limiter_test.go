@@ -0,0 +1,210 @@
+package ratelimiter
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+var epoch = time.Unix(1_000_000, 0)
+
+// admitted counts the events a limiter allows when offered one every
+// step for the given duration, without waiting.
+func admitted(r reserver, step, d time.Duration) int {
+	n := 0
+	for t := time.Duration(0); t < d; t += step {
+		if _, ok := r.reserve(epoch.Add(t), 0); ok {
+			n++
+		}
+	}
+	return n
+}
+
+func TestRates(t *testing.T) {
+	tests := []struct {
+		name string
+		r    reserver
+		want int
+	}{
+		// The initial burst of 5, then one token per 100ms; the 100th
+		// refill lands at 10s, just outside the offered range.
+		{"token bucket", NewTokenBucket(10, 5), 104},
+		// Strictly one event per 100ms.
+		{"leaky bucket", NewLeakyBucket(10, 5), 100},
+		// 20 per second over 10 whole windows.
+		{"sliding window", NewSlidingWindow(20, time.Second), 200},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := admitted(tt.r, time.Millisecond, 10*time.Second); got != tt.want {
+				t.Errorf("admitted %d events, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTokenBucketBurstAndDelay(t *testing.T) {
+	b := NewTokenBucket(10, 3)
+	for i := 0; i < 3; i++ {
+		if _, ok := b.reserve(epoch, 0); !ok {
+			t.Fatalf("burst event %d refused", i)
+		}
+	}
+	if _, ok := b.reserve(epoch, 0); ok {
+		t.Fatal("event beyond burst allowed without waiting")
+	}
+	at, ok := b.reserve(epoch, noLimit)
+	if !ok || at.Sub(epoch) != 100*time.Millisecond {
+		t.Fatalf("reserve = %v, %v; want 100ms, true", at.Sub(epoch), ok)
+	}
+}
+
+func TestLeakyBucketCapacity(t *testing.T) {
+	b := NewLeakyBucket(10, 2)
+	for i, want := range []time.Duration{0, 100 * time.Millisecond} {
+		at, ok := b.reserve(epoch, noLimit)
+		if !ok || at.Sub(epoch) != want {
+			t.Fatalf("reserve %d = %v, %v; want %v, true", i, at.Sub(epoch), ok, want)
+		}
+	}
+	at, ok := b.reserve(epoch, noLimit)
+	if ok {
+		t.Fatal("full bucket accepted a reservation")
+	}
+	if at.Sub(epoch) != 100*time.Millisecond {
+		t.Errorf("retry hint = %v, want 100ms", at.Sub(epoch))
+	}
+}
+
+func TestLeakyBucketCancelKeepsSpacing(t *testing.T) {
+	b := NewLeakyBucket(10, 5)
+	var slots []time.Time
+	for i := 0; i < 3; i++ {
+		at, _ := b.reserve(epoch, noLimit)
+		slots = append(slots, at)
+	}
+	b.cancel(slots[1], epoch)
+	at, ok := b.reserve(epoch, noLimit)
+	if !ok || at.Sub(epoch) != 300*time.Millisecond {
+		t.Fatalf("after cancelling a middle slot reserve = %v, %v; want 300ms", at.Sub(epoch), ok)
+	}
+
+	b.cancel(at, epoch)
+	if at, _ := b.reserve(epoch, noLimit); at.Sub(epoch) != 300*time.Millisecond {
+		t.Errorf("after cancelling the last slot reserve = %v, want 300ms", at.Sub(epoch))
+	}
+}
+
+func TestSlidingWindowDelay(t *testing.T) {
+	w := NewSlidingWindow(2, time.Second)
+	w.reserve(epoch, noLimit)
+	w.reserve(epoch.Add(100*time.Millisecond), noLimit)
+	at, ok := w.reserve(epoch.Add(200*time.Millisecond), noLimit)
+	if !ok || !at.Equal(epoch.Add(time.Second)) {
+		t.Fatalf("reserve = %v, %v; want 1s after epoch", at.Sub(epoch), ok)
+	}
+}
+
+func TestCancelReturnsCapacity(t *testing.T) {
+	tests := []struct {
+		name string
+		lim  Limiter
+	}{
+		{"token bucket", NewTokenBucket(1, 1)},
+		{"leaky bucket", NewLeakyBucket(1, 2)},
+		{"sliding window", NewSlidingWindow(1, time.Minute)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !tt.lim.Allow() {
+				t.Fatal("first event refused")
+			}
+			r := tt.lim.Reserve()
+			if !r.OK() || r.Delay() <= 0 {
+				t.Fatalf("reservation = %v, %v; want a delayed OK", r.OK(), r.Delay())
+			}
+			r.Cancel()
+			r.Cancel()
+			r2 := tt.lim.Reserve()
+			if !r2.OK() || r2.Delay() > r.Delay() {
+				t.Errorf("after cancel delay = %v, want at most %v", r2.Delay(), r.Delay())
+			}
+		})
+	}
+}
+
+func TestWaitCancelledGivesBackCapacity(t *testing.T) {
+	b := NewTokenBucket(1, 1)
+	b.Allow()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- b.Wait(ctx) }()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("Wait = %v, want context.Canceled", err)
+	}
+	if tok := b.Tokens(); tok < -0.01 {
+		t.Errorf("tokens = %.2f after cancelled wait, want >= 0", tok)
+	}
+}
+
+func TestWaitDeadline(t *testing.T) {
+	b := NewTokenBucket(1, 1)
+	b.Allow()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := b.Wait(ctx); !errors.Is(err, ErrLimited) {
+		t.Fatalf("Wait = %v, want ErrLimited", err)
+	}
+
+	fast := NewTokenBucket(100, 1)
+	fast.Allow()
+	if err := fast.Wait(context.Background()); err != nil {
+		t.Fatalf("Wait = %v", err)
+	}
+}
+
+func TestKeyedSweepsIdle(t *testing.T) {
+	k := NewKeyed(func() Limiter { return NewTokenBucket(1, 1) }, 10*time.Millisecond)
+	k.Allow("a")
+	k.Allow("b")
+	time.Sleep(20 * time.Millisecond)
+	k.Allow("c")
+	if n := k.Len(); n != 1 {
+		t.Errorf("Len = %d after idle sweep, want 1", n)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	k := NewKeyed(func() Limiter { return NewTokenBucket(0.5, 1) }, 0)
+	h := Middleware(k, KeyByIP)(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+
+	serve := func(addr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = addr
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+	if rec := serve("10.0.0.1:1000"); rec.Code != http.StatusOK {
+		t.Fatalf("first request: %d", rec.Code)
+	}
+	rec := serve("10.0.0.1:2000")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("second request: %d, want 429", rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "2" {
+		t.Errorf("Retry-After = %q, want 2", got)
+	}
+	if rec := serve("10.0.0.2:1000"); rec.Code != http.StatusOK {
+		t.Errorf("other client: %d", rec.Code)
+	}
+	// The rejected request must not have consumed capacity.
+	if r := k.Reserve("10.0.0.1"); r.Delay() > 2*time.Second {
+		t.Errorf("delay after rejection = %v, want <= 2s", r.Delay())
+	}
+}
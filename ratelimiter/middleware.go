@@ -0,0 +1,41 @@
+package ratelimiter
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+// KeyFunc extracts the rate-limiting key from a request.
+type KeyFunc func(r *http.Request) string
+
+// KeyByIP keys requests by the client IP from RemoteAddr.
+func KeyByIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Middleware rejects requests over the limit for their key with
+// 429 Too Many Requests and a Retry-After header in whole seconds.
+func Middleware(k *Keyed, key KeyFunc) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			res := k.Reserve(key(r))
+			if !res.OK() || res.Delay() > 0 {
+				res.Cancel()
+				secs := int64(math.Ceil(res.Delay().Seconds()))
+				if secs < 1 {
+					secs = 1
+				}
+				w.Header().Set("Retry-After", strconv.FormatInt(secs, 10))
+				http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package ratelimiter
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// SlidingWindow admits at most limit events in any window-long interval,
+// tracking the timestamp of every admitted event. It is exact, at the
+// cost of memory proportional to limit.
+type SlidingWindow struct {
+	mu     sync.Mutex
+	limit  int
+	window time.Duration
+	log    []time.Time // admitted (or reserved) event times, ascending
+}
+
+// NewSlidingWindow returns a limiter allowing limit events per window.
+func NewSlidingWindow(limit int, window time.Duration) *SlidingWindow {
+	if limit < 1 {
+		panic("ratelimiter: limit must be at least 1")
+	}
+	if window <= 0 {
+		panic("ratelimiter: window must be positive")
+	}
+	return &SlidingWindow{limit: limit, window: window, log: make([]time.Time, 0, limit)}
+}
+
+func (w *SlidingWindow) Allow() bool                    { return allow(w) }
+func (w *SlidingWindow) Wait(ctx context.Context) error { return wait(ctx, w) }
+func (w *SlidingWindow) Reserve() Reservation           { return reserve(w) }
+
+// Count returns the number of events in the current window.
+func (w *SlidingWindow) Count() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.evict(time.Now())
+	return len(w.log)
+}
+
+func (w *SlidingWindow) evict(now time.Time) {
+	cutoff := now.Add(-w.window)
+	i := 0
+	for i < len(w.log) && !w.log[i].After(cutoff) {
+		i++
+	}
+	if i > 0 {
+		w.log = append(w.log[:0], w.log[i:]...)
+	}
+}
+
+func (w *SlidingWindow) reserve(now time.Time, maxWait time.Duration) (time.Time, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.evict(now)
+
+	at := now
+	if n := len(w.log); n >= w.limit {
+		// The event has to wait until the limit-th most recent entry
+		// slides out of the window.
+		at = w.log[n-w.limit].Add(w.window)
+		if last := w.log[n-1]; last.After(at) {
+			at = last
+		}
+	}
+	if maxWait >= 0 && at.Sub(now) > maxWait {
+		return at, false
+	}
+	w.log = append(w.log, at)
+	return at, true
+}
+
+func (w *SlidingWindow) cancel(at, _ time.Time) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for i := len(w.log) - 1; i >= 0; i-- {
+		if w.log[i].Equal(at) {
+			w.log = append(w.log[:i], w.log[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package ratelimiter
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// TokenBucket refills at a fixed rate up to burst tokens; each event
+// takes one token. Bursts up to the bucket size pass immediately.
+type TokenBucket struct {
+	mu     sync.Mutex
+	rate   float64 // tokens per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewTokenBucket returns a full bucket refilled at rate tokens per second.
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	if rate <= 0 {
+		panic("ratelimiter: rate must be positive")
+	}
+	if burst < 1 {
+		panic("ratelimiter: burst must be at least 1")
+	}
+	return &TokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst)}
+}
+
+func (b *TokenBucket) Allow() bool                    { return allow(b) }
+func (b *TokenBucket) Wait(ctx context.Context) error { return wait(ctx, b) }
+func (b *TokenBucket) Reserve() Reservation           { return reserve(b) }
+
+// Tokens returns the tokens currently available.
+func (b *TokenBucket) Tokens() float64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(time.Now())
+	return b.tokens
+}
+
+func (b *TokenBucket) refill(now time.Time) {
+	if !b.last.IsZero() && now.After(b.last) {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+	}
+	if now.After(b.last) {
+		b.last = now
+	}
+}
+
+func (b *TokenBucket) reserve(now time.Time, maxWait time.Duration) (time.Time, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(now)
+
+	tokens := b.tokens - 1
+	var d time.Duration
+	if tokens < 0 {
+		d = time.Duration(-tokens / b.rate * float64(time.Second))
+	}
+	if maxWait >= 0 && d > maxWait {
+		return now.Add(d), false
+	}
+	b.tokens = tokens
+	return now.Add(d), true
+}
+
+func (b *TokenBucket) cancel(_, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(now)
+	b.tokens++
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+}